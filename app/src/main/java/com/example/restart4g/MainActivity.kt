@@ -2,6 +2,7 @@ package com.example.restart4g
 
 import android.content.Intent
 import android.content.pm.PackageManager
+import android.os.Build
 import android.os.Bundle
 import android.os.Handler
 import android.os.Looper
@@ -17,9 +18,18 @@ import org.eclipse.paho.client.mqttv3.*
 import rikka.shizuku.Shizuku
 import android.util.Log
 import android.view.WindowManager
+import org.json.JSONArray
+import org.json.JSONObject
 
 class MainActivity : AppCompatActivity() {
 
+    companion object {
+        // 服务端按设备类型生成的设备ID（device/oppo/status 上报的设备为 oppo-device）
+        private const val DEVICE_ID = "oppo-device"
+        // 预配置设备的令牌（POST /api/v1/enrollment/devices 返回），未预配置时留空
+        private const val DEVICE_TOKEN = ""
+    }
+
     private val handler = Handler(Looper.getMainLooper())
     private lateinit var mqttClient: MqttAndroidClient
     private lateinit var etServerUri: EditText
@@ -100,6 +110,7 @@ class MainActivity : AppCompatActivity() {
         val commandTopic = "device/oppo/restart4g"  // 接收命令的主题
         val statusTopic = "device/oppo/status"      // 发送状态的主题
         val registerTopic = "device/register"       // 设备注册主题
+        val willTopic = "device/will/$DEVICE_ID"    // 遗嘱主题
 
         try {
             mqttClient = MqttAndroidClient(applicationContext, serverUri, clientId)
@@ -113,6 +124,12 @@ class MainActivity : AppCompatActivity() {
                 maxInflight = 5          // 减少并发消息数
                 // 添加连接可靠性配置
                 setMqttVersion(MqttConnectOptions.MQTT_VERSION_3_1_1)
+                // 4G断开时无法主动上报离线，由broker在连接异常断开时代为发布遗嘱
+                val willPayload = JSONObject().apply {
+                    put("action", "offline")
+                    put("device_id", DEVICE_ID)
+                }.toString()
+                setWill(willTopic, willPayload.toByteArray(), 1, false)
             }
 
             mqttClient.setCallback(object : MqttCallbackExtended {
@@ -171,8 +188,8 @@ class MainActivity : AppCompatActivity() {
                         Toast.makeText(applicationContext, "收到命令: $msg", Toast.LENGTH_SHORT).show()
                     }
                     
-                    // 处理命令
-                    when (msg.trim()) {
+                    // 处理命令（参数以 ?key=value 形式附加在命令名之后）
+                    when (msg.trim().substringBefore('?')) {
                         "restart4g" -> {
                             sendStatus(statusTopic, "command_received: restart4g")
                             restart4GAuto()
@@ -229,17 +246,26 @@ class MainActivity : AppCompatActivity() {
         restart4GByAirplane()
     }
 
-    // 设备注册方法 - 简化数据包大小
+    // 设备注册方法：data 中声明协议（命令按 device/oppo/{command} 纯字符串下发）和能执行的命令
     private fun registerDevice(clientId: String, registerTopic: String) {
         try {
-            // 简化注册数据，减少包大小
-            val registerData = """
-                {
-                    "action": "register",
-                    "device_id": "oppo-device",
-                    "client_id": "$clientId"
-                }
-            """.trimIndent()
+            val data = JSONObject().apply {
+                put("device_id", DEVICE_ID)
+                put("client_id", clientId)
+                put("protocol", "oppo")
+                put("device_info", JSONObject().apply {
+                    put("device_type", "oppo")
+                    put("model", Build.MODEL)
+                })
+                put("capabilities", JSONArray().put("restart4g"))
+                // 服务端预配置了该设备时需要登记返回的令牌
+                if (DEVICE_TOKEN.isNotEmpty()) put("token", DEVICE_TOKEN)
+            }
+            val registerData = JSONObject().apply {
+                put("action", "register")
+                put("timestamp", System.currentTimeMillis() / 1000)
+                put("data", data)
+            }.toString()
             
             // 延迟发送，避免连接后立即发送大量数据
             Handler(Looper.getMainLooper()).postDelayed({
@@ -288,9 +314,12 @@ class MainActivity : AppCompatActivity() {
         Thread {
             try {
                 MobileDataController.restart4G()
+                // 附带重启后的蜂窝网络IP，服务端据此判断是否换到了新IP
+                val ip = getCellularIp()
+                val status = if (ip != null) "4g_restarted_success: ip=$ip" else "4g_restarted_success"
                 runOnUiThread {
                     Toast.makeText(this, "已重启 4G 网络", Toast.LENGTH_SHORT).show()
-                    sendStatus("device/oppo/status", "4g_restarted_success")
+                    sendStatus("device/oppo/status", status)
                 }
             } catch (e: Exception) {
                 runOnUiThread {
@@ -301,6 +330,20 @@ class MainActivity : AppCompatActivity() {
         }.start()
     }
     
+    // 获取蜂窝网络接口（rmnet/ccmni等）的IPv4地址
+    private fun getCellularIp(): String? {
+        return try {
+            java.net.NetworkInterface.getNetworkInterfaces().toList()
+                .filter { it.isUp && (it.name.startsWith("rmnet") || it.name.startsWith("ccmni")) }
+                .flatMap { it.inetAddresses.toList() }
+                .firstOrNull { it is java.net.Inet4Address && !it.isLoopbackAddress }
+                ?.hostAddress
+        } catch (e: Exception) {
+            Log.e("MainActivity", "获取蜂窝网络IP失败", e)
+            null
+        }
+    }
+
     /**
      * 控制屏幕常亮功能
      * @param keepOn true表示保持屏幕常亮，false表示允许屏幕自动熄灭
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"mobile-admin-mqtt-server/artifacts"
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/types"
+
+	"github.com/gorilla/mux"
+)
+
+// 设备访问本服务的地址：优先使用配置的公开地址，否则取自请求
+func (h *Handler) baseURL(r *http.Request) string {
+	if h.publicURL != "" {
+		return h.publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// 从路径中读取版本号
+func versionCodeFrom(r *http.Request) (int64, error) {
+	code, err := strconv.ParseInt(mux.Vars(r)["code"], 10, 64)
+	if err != nil || code <= 0 {
+		return 0, fmt.Errorf("invalid version code: %s", mux.Vars(r)["code"])
+	}
+	return code, nil
+}
+
+// 发送 update_app 命令，返回要安装的版本
+func (h *Handler) sendAppUpdate(w http.ResponseWriter, r *http.Request, t *tenant.Tenant, req types.CommandRequest, spec *command.Spec, params map[string]interface{}) {
+	release, err := h.artifacts.SendUpdate(r.Context(), t, req.DeviceID, spec, params, h.baseURL(r), req.Protocol)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Command sent successfully",
+		Data: map[string]interface{}{
+			"device_id": req.DeviceID,
+			"command":   req.Command,
+			"release":   release,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取应用版本列表（最新的在前）
+func (h *Handler) GetAppReleases(w http.ResponseWriter, r *http.Request) {
+	releases := h.artifacts.List(tenantFrom(r).ID)
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "App releases retrieved successfully",
+		Data:    releases,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 上传应用版本（multipart表单：file、version、version_code，可选 release_notes、sha256）
+func (h *Handler) UploadAppRelease(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.artifacts.MaxSize()+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: "Invalid upload: " + err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: "Invalid upload: file is required",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	defer file.Close()
+
+	code, _ := strconv.ParseInt(r.FormValue("version_code"), 10, 64)
+	release := &types.AppRelease{
+		VersionCode:  code,
+		Version:      r.FormValue("version"),
+		ReleaseNotes: r.FormValue("release_notes"),
+		FileName:     filepath.Base(header.Filename),
+	}
+
+	stored, err := h.artifacts.Upload(tenantFrom(r).ID, release, r.FormValue("sha256"), file)
+	if err != nil {
+		status := http.StatusBadRequest
+		if _, ok := err.(*artifacts.ReleaseExistsError); ok {
+			status = http.StatusConflict
+		}
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "App release uploaded successfully",
+		Data:    stored,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取应用版本
+func (h *Handler) GetAppRelease(w http.ResponseWriter, r *http.Request) {
+	code, err := versionCodeFrom(r)
+	var release *types.AppRelease
+	if err == nil {
+		release, err = h.artifacts.Get(tenantFrom(r).ID, code)
+	}
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "App release retrieved successfully",
+		Data:    release,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 删除应用版本，已下发的下载链接随之失效
+func (h *Handler) DeleteAppRelease(w http.ResponseWriter, r *http.Request) {
+	code, err := versionCodeFrom(r)
+	if err == nil {
+		err = h.artifacts.Delete(tenantFrom(r).ID, code)
+	}
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "App release deleted successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取应用版本分布：各版本上运行的设备
+func (h *Handler) GetAppRollout(w http.ResponseWriter, r *http.Request) {
+	rollout := h.artifacts.Rollout(tenantFrom(r).ID)
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "App rollout retrieved successfully",
+		Data:    rollout,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 设备下载APK，使用 update_app 命令中的签名链接，无需API凭证
+func (h *Handler) DownloadApp(w http.ResponseWriter, r *http.Request) {
+	tenantID := mux.Vars(r)["tenant"]
+	code, err := versionCodeFrom(r)
+	if err == nil {
+		err = h.artifacts.VerifyDownload(tenantID, code, r.URL.Query().Get("expires"), r.URL.Query().Get("sig"))
+	}
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	f, release, err := h.artifacts.Open(tenantID, code)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.android.package-archive")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", release.FileName))
+	w.Header().Set("X-Checksum-SHA256", release.SHA256)
+	http.ServeContent(w, r, "", release.UploadedAt, f)
+}
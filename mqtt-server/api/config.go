@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"mobile-admin-mqtt-server/device"
+	"mobile-admin-mqtt-server/types"
+
+	"github.com/gorilla/mux"
+)
+
+// 获取设备的配置文档（desired、reported、差异和版本）
+func (h *Handler) GetDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := h.deviceManager.GetConfig(tenantFrom(r).ID, mux.Vars(r)["id"])
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Device config retrieved successfully",
+		Data:    config,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 设置设备的期望配置，设备在线时立即下发差异
+func (h *Handler) SetDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+	tenantID := tenantFrom(r).ID
+
+	if _, err := h.deviceManager.GetDevice(tenantID, deviceID); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	var update types.ConfigUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	config, err := h.deviceManager.SetDesiredConfig(r.Context(), tenantID, deviceID, &update)
+	if err != nil {
+		status := http.StatusBadRequest
+		if _, ok := err.(*device.VersionConflictError); ok {
+			status = http.StatusConflict
+		}
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Device config updated successfully",
+		Data:    config,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"mobile-admin-mqtt-server/types"
+
+	"github.com/gorilla/mux"
+)
+
+// 获取预配置的设备（注册白名单）
+func (h *Handler) GetEnrolledDevices(w http.ResponseWriter, r *http.Request) {
+	response := types.APIResponse{
+		Success: true,
+		Message: "Provisioned devices retrieved successfully",
+		Data:    h.deviceManager.EnrolledDevices(tenantFrom(r).ID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 预配置设备，返回设备注册时使用的令牌
+func (h *Handler) ProvisionDevice(w http.ResponseWriter, r *http.Request) {
+	var req types.ProvisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	record, token, err := h.deviceManager.ProvisionDevice(tenantFrom(r).ID, req.DeviceID, req.Token)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Device provisioned successfully",
+		Data:    h.provisionResult(tenantFrom(r).ID, record, token),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// 为预配置的设备生成新令牌
+func (h *Handler) RotateDeviceToken(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+
+	token, err := h.deviceManager.RotateDeviceToken(tenantFrom(r).ID, deviceID)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Device token rotated successfully",
+		Data: map[string]string{
+			"device_id": deviceID,
+			"token":     token,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 撤销设备的预配置
+func (h *Handler) RevokeDevice(w http.ResponseWriter, r *http.Request) {
+	if err := h.deviceManager.RevokeDevice(tenantFrom(r).ID, mux.Vars(r)["id"]); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Device provisioning revoked",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取待审批的设备
+func (h *Handler) GetPendingDevices(w http.ResponseWriter, r *http.Request) {
+	response := types.APIResponse{
+		Success: true,
+		Message: "Pending devices retrieved successfully",
+		Data:    h.deviceManager.PendingDevices(tenantFrom(r).ID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 批准待审批的设备，返回设备注册时使用的令牌
+func (h *Handler) ApprovePendingDevice(w http.ResponseWriter, r *http.Request) {
+	record, token, err := h.deviceManager.ApprovePending(tenantFrom(r).ID, mux.Vars(r)["id"])
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Device approved successfully",
+		Data:    h.provisionResult(tenantFrom(r).ID, record, token),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// 从待审批列表中移除设备
+func (h *Handler) DismissPendingDevice(w http.ResponseWriter, r *http.Request) {
+	if err := h.deviceManager.DismissPending(tenantFrom(r).ID, mux.Vars(r)["id"]); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Pending device dismissed",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 预配置结果，启用命令签名时附带公钥，设备在预配置时固定公钥
+func (h *Handler) provisionResult(tenantID string, record *types.EnrolledDevice, token string) types.ProvisionResult {
+	result := types.ProvisionResult{Device: record, Token: token}
+	if h.signer != nil && h.signer.Mode() != types.SigningOff {
+		if keys, err := h.signer.Keys(tenantID); err == nil {
+			result.SigningKeys = keys
+		} else {
+			logger.Error("Failed to load signing keys", "tenant_id", tenantID, "error", err)
+		}
+	}
+	return result
+}
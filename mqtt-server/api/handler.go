@@ -1,44 +1,144 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 
+	"mobile-admin-mqtt-server/artifacts"
+	"mobile-admin-mqtt-server/command"
 	"mobile-admin-mqtt-server/device"
+	"mobile-admin-mqtt-server/logs"
+	"mobile-admin-mqtt-server/rotation"
+	"mobile-admin-mqtt-server/schema"
+	"mobile-admin-mqtt-server/signing"
+	"mobile-admin-mqtt-server/tenant"
 	"mobile-admin-mqtt-server/types"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// 请求上下文键
+type contextKey string
+
+const tenantContextKey contextKey = "tenant"
+
 // API处理器
 type Handler struct {
 	deviceManager *device.Manager
-	mqttClient    mqtt.Client
+	commands      *command.Registry
+	rotations     *rotation.Runner
+	signer        *signing.Keyring
+	validator     *schema.Validator
+	logCollector  *logs.Collector
+	artifacts     *artifacts.Store
+	// 设备访问本服务的地址（用于APK下载链接），为空时取自请求
+	publicURL  string
+	mqttClient mqtt.Client
+}
+
+// 命令目录项（附带当前租户下渲染后的目标主题）
+type catalogEntry struct {
+	*command.Spec
+	TopicPattern string `json:"topic_pattern"`
+}
+
+// 设备详情：设备信息 + 遥测历史
+type deviceDetail struct {
+	*types.Device
+	TelemetryHistory []types.Telemetry `json:"telemetry_history"`
 }
 
 // 创建新的API处理器
-func NewHandler(deviceManager *device.Manager) *Handler {
+func NewHandler(deviceManager *device.Manager, commands *command.Registry, rotations *rotation.Runner) *Handler {
 	return &Handler{
 		deviceManager: deviceManager,
+		commands:      commands,
+		rotations:     rotations,
 	}
 }
 
+// 设置命令签名密钥环（用于直接发送到主题的命令和密钥管理接口）
+func (h *Handler) SetSigner(signer *signing.Keyring) {
+	h.signer = signer
+}
+
+// 设置入站消息校验器（用于消息格式和拒绝统计接口）
+func (h *Handler) SetValidator(validator *schema.Validator) {
+	h.validator = validator
+}
+
+// 设置设备日志收集器（collect_logs 命令和日志下载接口）
+func (h *Handler) SetLogCollector(collector *logs.Collector) {
+	h.logCollector = collector
+}
+
+// 设置应用制品库（update_app 命令、版本管理和下载接口）
+func (h *Handler) SetArtifactStore(store *artifacts.Store, publicURL string) {
+	h.artifacts = store
+	h.publicURL = publicURL
+}
+
 // 设置MQTT客户端（用于直接发送消息）
 func (h *Handler) SetMQTTClient(client mqtt.Client) {
 	h.mqttClient = client
 }
 
+// 获取当前请求所属租户（由TenantMiddleware注入）
+func tenantFrom(r *http.Request) *tenant.Tenant {
+	t, _ := r.Context().Value(tenantContextKey).(*tenant.Tenant)
+	return t
+}
+
+// 从请求中读取API Key（X-API-Key 或 Authorization: Bearer）
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
 // 获取所有设备列表
 func (h *Handler) GetDevices(w http.ResponseWriter, r *http.Request) {
-	devices := h.deviceManager.GetAllDevices()
+	// 过滤、排序和分页参数见 parseDeviceQuery
+	query, err := parseDeviceQuery(r.URL.Query())
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	page, err := h.deviceManager.QueryDevices(tenantFrom(r).ID, query)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
 
 	response := types.APIResponse{
 		Success: true,
 		Message: "Devices retrieved successfully",
-		Data:    devices,
+		Data:    page,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -51,7 +151,7 @@ func (h *Handler) GetDevice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	deviceID := vars["id"]
 
-	device, err := h.deviceManager.GetDevice(deviceID)
+	device, err := h.deviceManager.GetDevice(tenantFrom(r).ID, deviceID)
 	if err != nil {
 		response := types.APIResponse{
 			Success: false,
@@ -64,9 +164,66 @@ func (h *Handler) GetDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 单个设备的详情中附带遥测历史
+	history, _ := h.deviceManager.TelemetryHistory(tenantFrom(r).ID, deviceID)
+
 	response := types.APIResponse{
 		Success: true,
 		Message: "Device retrieved successfully",
+		Data:    deviceDetail{Device: device, TelemetryHistory: history},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 更新设备的名称、位置、备注和设备信息覆盖项，或手动标记为离线
+func (h *Handler) UpdateDevice(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+	tenantID := tenantFrom(r).ID
+
+	if _, err := h.deviceManager.GetDevice(tenantID, deviceID); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	var update types.DeviceUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	device, err := h.deviceManager.UpdateDevice(tenantID, deviceID, &update)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Device updated successfully",
 		Data:    device,
 	}
 
@@ -75,6 +232,62 @@ func (h *Handler) GetDevice(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// 删除设备（同时取消该设备正在执行的换IP任务）
+func (h *Handler) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+	tenantID := tenantFrom(r).ID
+
+	if err := h.deviceManager.RemoveDevice(tenantID, deviceID); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	h.rotations.CancelDevice(tenantID, deviceID)
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Device removed successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取设备的IP变化记录和最近一次重启结果
+func (h *Handler) GetDeviceIPs(w http.ResponseWriter, r *http.Request) {
+	deviceID := mux.Vars(r)["id"]
+
+	report, err := h.deviceManager.IPReport(tenantFrom(r).ID, deviceID)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Device IP history retrieved successfully",
+		Data:    report,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
 // 发送命令给设备
 func (h *Handler) SendCommand(w http.ResponseWriter, r *http.Request) {
 	var req types.CommandRequest
@@ -90,17 +303,70 @@ func (h *Handler) SendCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 检查是否是Android客户端格式（通过topic字段判断）
-	var err error
+	// 根据命令注册表校验命令及参数
+	spec, ok := h.commands.Get(req.Command)
+	if !ok {
+		response := types.APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Unknown command: %s", req.Command),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	params, err := spec.ValidateParams(req.Params)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// 检查租户命令配额
+	t := tenantFrom(r)
+	if !t.AllowCommand() {
+		response := types.APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Command quota exceeded (%d per minute)", t.MaxCommandsPerMinute),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// 组合命令由服务端编排执行，返回任务
+	if spec.Composite {
+		h.startRotation(w, r, t, req, spec.WithDefaults(params))
+		return
+	}
+
+	// 收集日志时创建日志包，上传完成后通过 /devices/{id}/logs/{bundle} 下载
+	if spec.Name == logs.CommandName && h.logCollector != nil {
+		h.requestLogs(w, r, t, req, spec, spec.WithDefaults(params))
+		return
+	}
+
+	// 更新应用时附带制品库中APK的下载链接和摘要
+	if spec.Name == artifacts.CommandName && h.artifacts != nil {
+		h.sendAppUpdate(w, r, t, req, spec, spec.WithDefaults(params))
+		return
+	}
+
 	if req.Topic != "" {
 		// 使用指定的主题发送命令
-		err = h.sendCommandToTopic(req.Topic, req.Command)
-	} else if strings.Contains(req.DeviceID, "oppo") || strings.Contains(req.DeviceID, "android") {
-		// Android客户端格式
-		err = h.deviceManager.SendCommandToAndroid(req.DeviceID, req.Command)
+		err = h.sendCommandToTopic(t, req.DeviceID, req.Topic, spec, params)
 	} else {
-		// 标准格式
-		err = h.deviceManager.SendCommand(req.DeviceID, req.Command)
+		// 按设备协议（或请求中指定的协议）编码并发送
+		err = h.deviceManager.SendCommand(r.Context(), t.ID, req.DeviceID, spec, params, req.Protocol)
 	}
 
 	if err != nil {
@@ -118,10 +384,14 @@ func (h *Handler) SendCommand(w http.ResponseWriter, r *http.Request) {
 	response := types.APIResponse{
 		Success: true,
 		Message: "Command sent successfully",
-		Data: map[string]string{
+		Data: map[string]interface{}{
 			"device_id": req.DeviceID,
 			"command":   req.Command,
 			"topic":     req.Topic,
+			"params":    spec.WithDefaults(params),
+			"protocol":  req.Protocol,
+			// 为true时可通过 GET /devices/{id}/ips 的 last_restart 查看是否换到了新IP
+			"changes_ip": spec.ChangesIP,
 		},
 	}
 
@@ -130,6 +400,134 @@ func (h *Handler) SendCommand(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// 启动换IP任务（restart_until_new_ip）
+func (h *Handler) startRotation(w http.ResponseWriter, r *http.Request, t *tenant.Tenant, req types.CommandRequest, params map[string]interface{}) {
+	var job *types.RotationJob
+	err := fmt.Errorf("composite command %s is not supported", req.Command)
+	if req.Command == rotation.CommandName {
+		var opts types.RotationOptions
+		if opts, err = rotation.OptionsFromParams(params); err == nil {
+			job, err = h.rotations.Start(r.Context(), t, req.DeviceID, opts)
+		}
+	}
+
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Rotation job started",
+		Data:    job,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取换IP任务列表，可按 ?device_id= 过滤
+func (h *Handler) GetRotations(w http.ResponseWriter, r *http.Request) {
+	jobs := h.rotations.List(tenantFrom(r).ID, r.URL.Query().Get("device_id"))
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Rotation jobs retrieved successfully",
+		Data:    jobs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取换IP任务（含每次尝试的结果）
+func (h *Handler) GetRotation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	job, ok := h.rotations.Get(tenantFrom(r).ID, id)
+	if !ok {
+		response := types.APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("rotation job not found: %s", id),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Rotation job retrieved successfully",
+		Data:    job,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 取消换IP任务
+func (h *Handler) CancelRotation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.rotations.Cancel(tenantFrom(r).ID, id); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Rotation job cancelled",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取命令目录
+func (h *Handler) GetCommandCatalog(w http.ResponseWriter, r *http.Request) {
+	t := tenantFrom(r)
+
+	specs := h.commands.All()
+	catalog := make([]catalogEntry, 0, len(specs))
+	for _, spec := range specs {
+		catalog = append(catalog, catalogEntry{
+			Spec:         spec,
+			TopicPattern: t.Topics.Pattern(spec.Topic),
+		})
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Command catalog retrieved successfully",
+		Data:    catalog,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
 // 健康检查
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	response := types.APIResponse{
@@ -150,8 +548,9 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID, traceparent, tracestate")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Trace-ID")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -162,13 +561,65 @@ func (h *Handler) CORSMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// 租户认证中间件：根据API Key确定租户并注入请求上下文
+func (h *Handler) TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenants := h.deviceManager.Tenants()
+
+		// 未配置API Key时为单租户模式，所有请求归属唯一的租户
+		t := tenants.All()[0]
+		if tenants.AuthRequired() {
+			var ok bool
+			if t, ok = tenants.ByAPIKey(apiKeyFromRequest(r)); !ok {
+				response := types.APIResponse{
+					Success: false,
+					Message: "Invalid or missing API key",
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(response)
+				return
+			}
+		}
+
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("tenant_id", t.ID))
+		ctx := context.WithValue(r.Context(), tenantContextKey, t)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // 发送命令到指定主题
-func (h *Handler) sendCommandToTopic(topic, command string) error {
+func (h *Handler) sendCommandToTopic(t *tenant.Tenant, deviceID, topic string, spec *command.Spec, params map[string]interface{}) error {
 	if h.mqttClient == nil {
 		return fmt.Errorf("MQTT client not set")
 	}
 
-	token := h.mqttClient.Publish(topic, 1, false, command)
+	if strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("topic must not contain wildcards: %s", topic)
+	}
+
+	// 已注册的设备声明了能力时，不发送未声明的命令
+	if deviceID != "" {
+		if _, err := h.deviceManager.GetDevice(t.ID, deviceID); err == nil {
+			if err := h.deviceManager.CheckCapability(t.ID, deviceID, spec.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 原始主题限定在租户的命名空间内，无法发布到其他租户的主题
+	topic = t.Topics.Qualify(topic)
+
+	payload := []byte(command.EncodePlain(spec.Name, params))
+	if h.signer != nil {
+		var err error
+		if payload, err = h.signer.SignCommand(t.ID, deviceID, topic, spec.Name, spec.WithDefaults(params), payload); err != nil {
+			return err
+		}
+	}
+
+	token := h.mqttClient.Publish(topic, 1, false, payload)
 	token.Wait()
 
 	if token.Error() != nil {
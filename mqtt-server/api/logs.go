@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/types"
+
+	"github.com/gorilla/mux"
+)
+
+// 下发 collect_logs 命令并返回等待上传的日志包
+func (h *Handler) requestLogs(w http.ResponseWriter, r *http.Request, t *tenant.Tenant, req types.CommandRequest, spec *command.Spec, params map[string]interface{}) {
+	bundle, err := h.logCollector.Request(r.Context(), t, req.DeviceID, spec, params, req.Protocol)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Log collection requested",
+		Data:    bundle,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取设备的日志包列表（最新的在前）
+func (h *Handler) GetDeviceLogs(w http.ResponseWriter, r *http.Request) {
+	if h.logCollector == nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: "Log collection is not enabled",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	bundles := h.logCollector.List(tenantFrom(r).ID, mux.Vars(r)["id"])
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Log bundles retrieved successfully",
+		Data:    bundles,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 下载日志包；尚未上传完成或失败时返回409和日志包状态
+func (h *Handler) GetDeviceLog(w http.ResponseWriter, r *http.Request) {
+	if h.logCollector == nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: "Log collection is not enabled",
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	vars := mux.Vars(r)
+	f, bundle, err := h.logCollector.Open(tenantFrom(r).ID, vars["id"], vars["bundle"])
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		status := http.StatusNotFound
+		if bundle != nil && bundle.Status != types.LogBundleComplete {
+			status = http.StatusConflict
+			response.Data = bundle
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bundle.DeviceID+"-"+bundle.ID+".log"))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("X-Log-SHA256", bundle.SHA256)
+	http.ServeContent(w, r, "", *bundle.CompletedAt, f)
+}
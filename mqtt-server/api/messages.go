@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// 消息格式的JSON Schema文档
+type messageSchema struct {
+	Name    string                 `json:"name"`
+	Version int                    `json:"version"`
+	Schema  map[string]interface{} `json:"schema"`
+}
+
+// 获取设备入站消息的格式定义（JSON Schema）
+func (h *Handler) GetMessageSchemas(w http.ResponseWriter, r *http.Request) {
+	schemas := make([]messageSchema, 0)
+	for _, s := range h.validator.Schemas() {
+		schemas = append(schemas, messageSchema{Name: s.Name, Version: s.Version, Schema: s.JSONSchema()})
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Message schemas retrieved successfully",
+		Data:    schemas,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 获取当前租户被拒绝的入站消息统计和最近的样本
+func (h *Handler) GetMessageRejects(w http.ResponseWriter, r *http.Request) {
+	response := types.APIResponse{
+		Success: true,
+		Message: "Message rejects retrieved successfully",
+		Data:    h.validator.Rejects(tenantFrom(r).ID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// 设备遥测指标：名称、说明、类型（累计值为counter）和取值函数（未上报时返回false）
+var telemetryMetrics = []struct {
+	name  string
+	help  string
+	kind  string
+	value func(t *types.Telemetry) (float64, bool)
+}{
+	{"m4g_device_rsrp_dbm", "Reference signal received power in dBm.", "gauge", func(t *types.Telemetry) (float64, bool) {
+		return floatValue(t.RSRP)
+	}},
+	{"m4g_device_rsrq_db", "Reference signal received quality in dB.", "gauge", func(t *types.Telemetry) (float64, bool) {
+		return floatValue(t.RSRQ)
+	}},
+	{"m4g_device_sinr_db", "Signal to interference plus noise ratio in dB.", "gauge", func(t *types.Telemetry) (float64, bool) {
+		return floatValue(t.SINR)
+	}},
+	{"m4g_device_battery_percent", "Battery level in percent.", "gauge", func(t *types.Telemetry) (float64, bool) {
+		if t.Battery == nil {
+			return 0, false
+		}
+		return float64(*t.Battery), true
+	}},
+	{"m4g_device_data_rx_bytes_total", "Mobile data received in bytes since the device counter was last reset.", "counter", func(t *types.Telemetry) (float64, bool) {
+		if t.DataRxBytes == nil {
+			return 0, false
+		}
+		return float64(*t.DataRxBytes), true
+	}},
+	{"m4g_device_data_tx_bytes_total", "Mobile data transmitted in bytes since the device counter was last reset.", "counter", func(t *types.Telemetry) (float64, bool) {
+		if t.DataTxBytes == nil {
+			return 0, false
+		}
+		return float64(*t.DataTxBytes), true
+	}},
+}
+
+// 导出当前租户设备的指标（Prometheus文本格式）
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	t := tenantFrom(r)
+	devices := h.deviceManager.MetricsSnapshot(t.ID)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeHeader(w, "m4g_devices", "Number of registered devices.", "gauge")
+	fmt.Fprintf(w, "m4g_devices{tenant=\"%s\"} %d\n", escapeLabel(t.ID), len(devices))
+
+	writeHeader(w, "m4g_device_online", "Whether the device is online.", "gauge")
+	for _, d := range devices {
+		online := 0
+		if d.IsOnline {
+			online = 1
+		}
+		fmt.Fprintf(w, "m4g_device_online{%s} %d\n", deviceLabels(t.ID, d.DeviceID), online)
+	}
+
+	writeHeader(w, "m4g_device_status", "Normalized network status of the device.", "gauge")
+	for _, d := range devices {
+		fmt.Fprintf(w, "m4g_device_status{%s,status=\"%s\"} 1\n", deviceLabels(t.ID, d.DeviceID), escapeLabel(string(d.Status)))
+	}
+
+	writeHeader(w, "m4g_device_network_info", "Carrier, cell and network type reported by the device.", "gauge")
+	for _, d := range devices {
+		if d.Telemetry == nil {
+			continue
+		}
+		fmt.Fprintf(w, "m4g_device_network_info{%s,carrier=\"%s\",cell_id=\"%s\",network_type=\"%s\"} 1\n",
+			deviceLabels(t.ID, d.DeviceID), escapeLabel(d.Telemetry.Carrier), escapeLabel(d.Telemetry.CellID), escapeLabel(d.Telemetry.NetworkType))
+	}
+
+	for _, metric := range telemetryMetrics {
+		writeHeader(w, metric.name, metric.help, metric.kind)
+		for _, d := range devices {
+			if d.Telemetry == nil {
+				continue
+			}
+			if v, ok := metric.value(d.Telemetry); ok {
+				fmt.Fprintf(w, "%s{%s} %g\n", metric.name, deviceLabels(t.ID, d.DeviceID), v)
+			}
+		}
+	}
+
+	if h.validator != nil {
+		writeHeader(w, "m4g_messages_rejected_total", "Inbound device messages rejected by schema validation.", "counter")
+		for _, stats := range h.validator.Rejects(t.ID) {
+			fmt.Fprintf(w, "m4g_messages_rejected_total{tenant=\"%s\",message=\"%s\"} %d\n", escapeLabel(t.ID), escapeLabel(stats.Message), stats.Count)
+		}
+	}
+}
+
+// 输出指标的HELP和TYPE行
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// 设备的公共标签
+func deviceLabels(tenantID, deviceID string) string {
+	return fmt.Sprintf("tenant=\"%s\",device_id=\"%s\"", escapeLabel(tenantID), escapeLabel(deviceID))
+}
+
+// 标签值转义（反斜杠、双引号和换行）
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func floatValue(v *float64) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	return *v, true
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"mobile-admin-mqtt-server/device"
+)
+
+// 解析设备列表的查询参数：
+//
+//	online=true|false            按在线状态过滤
+//	device_type=oppo,xiaomi      按设备类型过滤
+//	status=restarting,...        按网络状态过滤
+//	info.<key>=<value>           按设备信息字段过滤，如 info.model=PCLM10
+//	last_seen_after/before       最后活跃时间范围（RFC3339或Unix秒）
+//	last_seen_within=10m         最近一段时间内活跃
+//	q=<text>                     搜索设备ID、客户端ID、名称、位置、备注、IP、运营商和设备信息
+//	sort=last_seen|-last_seen    排序字段，"-" 表示降序
+//	limit=100&cursor=<next>      分页
+func parseDeviceQuery(values url.Values) (device.Query, error) {
+	var q device.Query
+
+	if v := values.Get("online"); v != "" {
+		online, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid online value %q", v)
+		}
+		q.Online = &online
+	}
+
+	q.DeviceTypes = splitValues(values.Get("device_type"))
+
+	for _, name := range splitValues(values.Get("status")) {
+		state, err := device.ParseState(name)
+		if err != nil {
+			return q, err
+		}
+		q.Statuses = append(q.Statuses, state)
+	}
+
+	for key := range values {
+		if field := strings.TrimPrefix(key, "info."); field != key && field != "" {
+			if q.Info == nil {
+				q.Info = make(map[string]string)
+			}
+			q.Info[field] = values.Get(key)
+		}
+	}
+
+	var err error
+	if q.LastSeenAfter, err = parseTime(values.Get("last_seen_after")); err != nil {
+		return q, fmt.Errorf("invalid last_seen_after: %v", err)
+	}
+	if q.LastSeenBefore, err = parseTime(values.Get("last_seen_before")); err != nil {
+		return q, fmt.Errorf("invalid last_seen_before: %v", err)
+	}
+	if v := values.Get("last_seen_within"); v != "" {
+		within, err := time.ParseDuration(v)
+		if err != nil || within <= 0 {
+			return q, fmt.Errorf("invalid last_seen_within %q, expected a duration such as 10m", v)
+		}
+		q.LastSeenAfter = time.Now().Add(-within)
+	}
+
+	q.Search = strings.TrimSpace(values.Get("q"))
+	q.Sort = values.Get("sort")
+	q.Cursor = values.Get("cursor")
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	return q, nil
+}
+
+// 拆分逗号分隔的参数值
+func splitValues(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// 解析RFC3339时间或Unix秒
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"mobile-admin-mqtt-server/logging"
+
+	"github.com/google/uuid"
+)
+
+var logger = logging.Logger("http")
+
+const requestIDContextKey contextKey = "request_id"
+
+// 客户端提供的请求ID的最大长度，超过时重新生成
+const maxRequestIDLength = 128
+
+// 记录响应状态码和字节数
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// 请求ID和访问日志中间件：沿用客户端的 X-Request-ID，否则生成新的，并在响应头中返回
+func (h *Handler) LoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id))
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		level := logging.LevelTrace
+		switch {
+		case recorder.status >= 500:
+			level = slog.LevelError
+		case r.Method != http.MethodOptions:
+			level = slog.LevelInfo
+		}
+		logger.Log(r.Context(), level, "HTTP request",
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"mobile-admin-mqtt-server/types"
+
+	"github.com/gorilla/mux"
+)
+
+// 获取命令签名公钥（JWKS格式的 keys 数组）和签名模式
+func (h *Handler) GetSigningKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.signer.Keys(tenantFrom(r).ID)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Signing keys retrieved successfully",
+		Data: map[string]interface{}{
+			"mode": h.signer.Mode(),
+			"keys": keys,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 轮换签名密钥，旧密钥标记为retired但仍保留用于验证
+func (h *Handler) RotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.signer.Rotate(tenantFrom(r).ID)
+	if err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Signing key rotated successfully",
+		Data:    key,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// 删除已retired的签名密钥
+func (h *Handler) DeleteSigningKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.signer.Delete(tenantFrom(r).ID, mux.Vars(r)["kid"]); err != nil {
+		response := types.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	response := types.APIResponse{
+		Success: true,
+		Message: "Signing key deleted",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(response)
+}
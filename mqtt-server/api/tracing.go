@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"mobile-admin-mqtt-server/tracing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("http")
+
+// 追踪中间件：沿用请求头中的 traceparent，为每个请求创建span，并在 X-Trace-ID 响应头中返回追踪ID。
+// 需在 LoggingMiddleware 之前注册，访问日志才能带上 trace_id
+func (h *Handler) TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+			))
+		defer span.End()
+
+		if sc := span.SpanContext(); sc.IsValid() {
+			w.Header().Set("X-Trace-ID", sc.TraceID().String())
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(recorder.status),
+			attribute.String("request_id", w.Header().Get("X-Request-ID")),
+		)
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
@@ -0,0 +1,469 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/device"
+	"mobile-admin-mqtt-server/logging"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/types"
+)
+
+var logger = logging.Logger("artifacts")
+
+// 通知设备安装新版本的命令名称
+const CommandName = "update_app"
+
+// 设备在 device_info 中上报的应用版本
+const (
+	InfoAppVersion     = "app_version"
+	InfoAppVersionCode = "app_version_code"
+)
+
+// 下载链接的有效期
+const DownloadTTL = 24 * time.Hour
+
+// APK（zip）文件头
+var apkMagic = []byte("PK\x03\x04")
+
+// 同一版本号已存在
+type ReleaseExistsError struct {
+	VersionCode int64
+}
+
+func (e *ReleaseExistsError) Error() string {
+	return fmt.Sprintf("version %d already exists", e.VersionCode)
+}
+
+// 应用制品库：保存上传的APK，生成设备下载链接并统计各版本的设备分布
+type Store struct {
+	devices *device.Manager
+	// 保存目录：<dir>/<tenant>/<version_code>.apk 和 .json（元数据）
+	dir     string
+	maxSize int64
+	// 签名下载链接的密钥，保存在 <dir>/download.key，重启后链接仍然有效
+	key []byte
+
+	releases map[string]map[int64]*types.AppRelease
+	mutex    sync.RWMutex
+}
+
+// 创建制品库并加载目录中已上传的版本；maxSize 为单个APK的最大字节数
+func NewStore(devices *device.Manager, dir string, maxSize int64) (*Store, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("artifact size limit must be positive")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %v", err)
+	}
+
+	s := &Store{
+		devices:  devices,
+		dir:      dir,
+		maxSize:  maxSize,
+		releases: make(map[string]map[int64]*types.AppRelease),
+	}
+	if err := s.loadKey(); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 单个APK的最大字节数
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// 读取下载链接密钥，不存在时生成
+func (s *Store) loadKey() error {
+	path := filepath.Join(s.dir, "download.key")
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		if s.key, err = hex.DecodeString(strings.TrimSpace(string(raw))); err != nil || len(s.key) < 32 {
+			return fmt.Errorf("invalid download key in %s", path)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read download key: %v", err)
+	}
+
+	s.key = make([]byte, 32)
+	if _, err := rand.Read(s.key); err != nil {
+		return fmt.Errorf("failed to generate download key: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(s.key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write download key: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) load() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*", "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list artifacts: %v", err)
+	}
+
+	count := 0
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			logger.Warn("Failed to read artifact", "path", path, "error", err)
+			continue
+		}
+		var release types.AppRelease
+		if err := json.Unmarshal(raw, &release); err != nil || release.VersionCode <= 0 {
+			logger.Warn("Ignoring invalid artifact file", "path", path)
+			continue
+		}
+		if s.path(release.TenantID, release.VersionCode, ".json") != path {
+			logger.Warn("Ignoring misplaced artifact file", "path", path)
+			continue
+		}
+		if _, err := os.Stat(s.path(release.TenantID, release.VersionCode, ".apk")); err != nil {
+			logger.Warn("Ignoring artifact without APK file", "path", path)
+			continue
+		}
+		s.partition(release.TenantID)[release.VersionCode] = &release
+		count++
+	}
+	logger.Info("Loaded app releases", "count", count, "dir", s.dir)
+	return nil
+}
+
+// 租户的保存目录，租户ID转义后作为目录名
+func (s *Store) tenantDir(tenantID string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(url.PathEscape(tenantID), ".", "%2E"))
+}
+
+func (s *Store) path(tenantID string, versionCode int64, ext string) string {
+	return filepath.Join(s.tenantDir(tenantID), strconv.FormatInt(versionCode, 10)+ext)
+}
+
+func (s *Store) partition(tenantID string) map[int64]*types.AppRelease {
+	partition, ok := s.releases[tenantID]
+	if !ok {
+		partition = make(map[int64]*types.AppRelease)
+		s.releases[tenantID] = partition
+	}
+	return partition
+}
+
+// 保存上传的APK；expectedSHA256 非空时校验上传内容的摘要
+func (s *Store) Upload(tenantID string, release *types.AppRelease, expectedSHA256 string, r io.Reader) (*types.AppRelease, error) {
+	if release.VersionCode <= 0 {
+		return nil, fmt.Errorf("version_code must be a positive integer")
+	}
+	if release.Version == "" {
+		return nil, fmt.Errorf("version is required")
+	}
+
+	s.mutex.RLock()
+	_, exists := s.releases[tenantID][release.VersionCode]
+	s.mutex.RUnlock()
+	if exists {
+		return nil, &ReleaseExistsError{VersionCode: release.VersionCode}
+	}
+
+	if err := os.MkdirAll(s.tenantDir(tenantID), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(s.tenantDir(tenantID), "upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to store artifact: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, s.maxSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store artifact: %v", err)
+	}
+	if size > s.maxSize {
+		return nil, fmt.Errorf("artifact exceeds the size limit of %d bytes", s.maxSize)
+	}
+	if err := checkAPK(tmp.Name()); err != nil {
+		return nil, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if expectedSHA256 != "" && !strings.EqualFold(expectedSHA256, sum) {
+		return nil, fmt.Errorf("sha256 mismatch: expected %s, got %s", expectedSHA256, sum)
+	}
+
+	stored := *release
+	stored.TenantID = tenantID
+	stored.Size = size
+	stored.SHA256 = sum
+	stored.UploadedAt = time.Now()
+	if stored.FileName == "" {
+		stored.FileName = fmt.Sprintf("app-%s.apk", stored.Version)
+	}
+	meta, err := json.MarshalIndent(&stored, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal artifact: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.releases[tenantID][release.VersionCode]; exists {
+		return nil, &ReleaseExistsError{VersionCode: release.VersionCode}
+	}
+	if err := os.Rename(tmp.Name(), s.path(tenantID, stored.VersionCode, ".apk")); err != nil {
+		return nil, fmt.Errorf("failed to store artifact: %v", err)
+	}
+	if err := os.WriteFile(s.path(tenantID, stored.VersionCode, ".json"), meta, 0600); err != nil {
+		os.Remove(s.path(tenantID, stored.VersionCode, ".apk"))
+		return nil, fmt.Errorf("failed to store artifact: %v", err)
+	}
+	s.partition(tenantID)[stored.VersionCode] = &stored
+
+	logger.Info("App release uploaded", "tenant_id", tenantID, "version", stored.Version, "version_code", stored.VersionCode, "size", size, "sha256", sum)
+	copied := stored
+	return &copied, nil
+}
+
+// 检查文件是否为APK（zip格式）
+func checkAPK(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read artifact: %v", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, len(apkMagic))
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header, apkMagic) {
+		return fmt.Errorf("file is not an APK")
+	}
+	return nil
+}
+
+// 租户的所有版本（副本），最新的在前
+func (s *Store) List(tenantID string) []*types.AppRelease {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]*types.AppRelease, 0, len(s.releases[tenantID]))
+	for _, release := range s.releases[tenantID] {
+		copied := *release
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].VersionCode > result[j].VersionCode
+	})
+	return result
+}
+
+// 获取版本（副本）
+func (s *Store) Get(tenantID string, versionCode int64) (*types.AppRelease, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	release, ok := s.releases[tenantID][versionCode]
+	if !ok {
+		return nil, fmt.Errorf("app release not found: %d", versionCode)
+	}
+	copied := *release
+	return &copied, nil
+}
+
+// 最新（versionCode最大）的版本
+func (s *Store) Latest(tenantID string) (*types.AppRelease, error) {
+	releases := s.List(tenantID)
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("no app release uploaded")
+	}
+	return releases[0], nil
+}
+
+// 删除版本及其APK
+func (s *Store) Delete(tenantID string, versionCode int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.releases[tenantID][versionCode]; !ok {
+		return fmt.Errorf("app release not found: %d", versionCode)
+	}
+	for _, ext := range []string{".json", ".apk"} {
+		if err := os.Remove(s.path(tenantID, versionCode, ext)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete app release: %v", err)
+		}
+	}
+	delete(s.releases[tenantID], versionCode)
+	logger.Info("App release deleted", "tenant_id", tenantID, "version_code", versionCode)
+	return nil
+}
+
+// 打开版本的APK文件
+func (s *Store) Open(tenantID string, versionCode int64) (*os.File, *types.AppRelease, error) {
+	release, err := s.Get(tenantID, versionCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(s.path(tenantID, versionCode, ".apk"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open app release %d: %v", versionCode, err)
+	}
+	return f, release, nil
+}
+
+func (s *Store) signature(tenantID string, versionCode, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%d\n%d", tenantID, versionCode, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 生成设备无需API凭证即可使用的签名下载链接，baseURL 为本服务对设备可见的地址
+func (s *Store) DownloadURL(baseURL, tenantID string, versionCode int64) string {
+	expires := time.Now().Add(DownloadTTL).Unix()
+	return fmt.Sprintf("%s/api/v1/downloads/apps/%s/%d?expires=%d&sig=%s",
+		strings.TrimRight(baseURL, "/"), url.PathEscape(tenantID), versionCode, expires, s.signature(tenantID, versionCode, expires))
+}
+
+// 校验下载链接的签名和有效期
+func (s *Store) VerifyDownload(tenantID string, versionCode int64, expires, sig string) error {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid download link")
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signature(tenantID, versionCode, exp))) {
+		return fmt.Errorf("invalid download link")
+	}
+	if time.Now().Unix() > exp {
+		return fmt.Errorf("download link expired")
+	}
+	return nil
+}
+
+// 向设备发送 update_app 命令；params 中未指定 version_code 时使用最新版本
+func (s *Store) SendUpdate(ctx context.Context, t *tenant.Tenant, deviceID string, spec *command.Spec, params map[string]interface{}, baseURL, protocol string) (*types.AppRelease, error) {
+	var release *types.AppRelease
+	var err error
+	if code, ok := params["version_code"].(int); ok {
+		release, err = s.Get(t.ID, int64(code))
+	} else {
+		release, err = s.Latest(t.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make(map[string]interface{}, len(params)+5)
+	for name, value := range params {
+		payload[name] = value
+	}
+	payload["version_code"] = release.VersionCode
+	payload["version"] = release.Version
+	payload["url"] = s.DownloadURL(baseURL, t.ID, release.VersionCode)
+	payload["sha256"] = release.SHA256
+	payload["size"] = release.Size
+
+	if err := s.devices.SendCommand(ctx, t.ID, deviceID, spec, payload, protocol); err != nil {
+		return nil, err
+	}
+	logger.InfoContext(ctx, "App update sent", "tenant_id", t.ID, "device_id", deviceID, "version", release.Version, "version_code", release.VersionCode)
+	return release, nil
+}
+
+// 按设备上报的应用版本统计各版本上运行的设备
+func (s *Store) Rollout(tenantID string) *types.AppRollout {
+	releases := s.List(tenantID)
+	byName := make(map[string]*types.AppRelease, len(releases))
+	byCode := make(map[int64]*types.AppRelease, len(releases))
+	for _, release := range releases {
+		byName[release.Version] = release
+		byCode[release.VersionCode] = release
+	}
+
+	rollout := &types.AppRollout{Versions: []types.AppVersionDevices{}, Unknown: []string{}}
+	if len(releases) > 0 {
+		rollout.LatestVersionCode = releases[0].VersionCode
+	}
+	// 已上传的版本即使没有设备也列出
+	groups := make(map[string]*types.AppVersionDevices)
+	for _, release := range releases {
+		groups[strconv.FormatInt(release.VersionCode, 10)] = &types.AppVersionDevices{
+			VersionCode: release.VersionCode,
+			Version:     release.Version,
+			Released:    true,
+			Devices:     []string{},
+		}
+	}
+
+	for _, d := range s.devices.GetAllDevices(tenantID) {
+		rollout.Total++
+		version := d.DeviceInfo[InfoAppVersion]
+		code, _ := strconv.ParseInt(d.DeviceInfo[InfoAppVersionCode], 10, 64)
+		// 只上报了 versionName 时按名称对应到已上传的版本
+		if code <= 0 {
+			if release, ok := byName[version]; ok && version != "" {
+				code = release.VersionCode
+			}
+		}
+
+		var key string
+		switch {
+		case code > 0:
+			key = strconv.FormatInt(code, 10)
+		case version != "":
+			key = "name:" + version
+		default:
+			rollout.Unknown = append(rollout.Unknown, d.ID)
+			continue
+		}
+
+		group, ok := groups[key]
+		if !ok {
+			group = &types.AppVersionDevices{VersionCode: code, Version: version, Devices: []string{}}
+			if release, ok := byCode[code]; ok {
+				group.Version = release.Version
+				group.Released = true
+			}
+			groups[key] = group
+		}
+		group.Devices = append(group.Devices, d.ID)
+		if d.IsOnline {
+			group.Online++
+		}
+		if code > 0 && code == rollout.LatestVersionCode {
+			rollout.UpToDate++
+		}
+	}
+
+	for _, group := range groups {
+		sort.Strings(group.Devices)
+		rollout.Versions = append(rollout.Versions, *group)
+	}
+	sort.Slice(rollout.Versions, func(i, j int) bool {
+		a, b := rollout.Versions[i], rollout.Versions[j]
+		if a.VersionCode != b.VersionCode {
+			return a.VersionCode > b.VersionCode
+		}
+		return a.Version > b.Version
+	})
+	sort.Strings(rollout.Unknown)
+	return rollout
+}
@@ -0,0 +1,113 @@
+package command
+
+import "mobile-admin-mqtt-server/topics"
+
+// 取值范围辅助函数
+func bound(v float64) *float64 {
+	return &v
+}
+
+// 内置命令
+func builtinSpecs() []Spec {
+	return []Spec{
+		{
+			Name:        "restart4g",
+			Description: "重启4G移动数据",
+			Params: []Param{
+				{Name: "delay_seconds", Type: ParamInt, Description: "执行前等待的秒数", Default: 0, Min: bound(0), Max: bound(300)},
+			},
+			Topic:          topics.NameCommand,
+			Encoding:       EncodingJSON,
+			TimeoutSeconds: 120,
+			ResultStatuses: []string{"4g_restarted_success", "4g_restart_failed"},
+			ChangesIP:      true,
+		},
+		{
+			Name:           "enable_data",
+			Description:    "开启移动数据",
+			Topic:          topics.NameCommand,
+			Encoding:       EncodingJSON,
+			TimeoutSeconds: 30,
+			ResultStatuses: []string{"data_enabled", "data_enable_failed"},
+		},
+		{
+			Name:           "disable_data",
+			Description:    "关闭移动数据",
+			Topic:          topics.NameCommand,
+			Encoding:       EncodingJSON,
+			TimeoutSeconds: 30,
+			ResultStatuses: []string{"data_disabled", "data_disable_failed"},
+		},
+		{
+			Name:        "airplane_toggle",
+			Description: "开启飞行模式一段时间后关闭，用于重新附着网络",
+			Params: []Param{
+				{Name: "duration_seconds", Type: ParamInt, Description: "飞行模式持续的秒数", Default: 5, Min: bound(1), Max: bound(120)},
+			},
+			Topic:          topics.NameCommand,
+			Encoding:       EncodingJSON,
+			TimeoutSeconds: 180,
+			ResultStatuses: []string{"airplane_toggled", "airplane_toggle_failed"},
+			ChangesIP:      true,
+		},
+		{
+			Name:        "reboot",
+			Description: "重启设备",
+			Params: []Param{
+				{Name: "delay_seconds", Type: ParamInt, Description: "执行前等待的秒数", Default: 0, Min: bound(0), Max: bound(300)},
+			},
+			Topic:          topics.NameCommand,
+			Encoding:       EncodingJSON,
+			TimeoutSeconds: 300,
+			ResultStatuses: []string{"rebooting", "reboot_failed"},
+			ChangesIP:      true,
+		},
+		{
+			Name:           "ping",
+			Description:    "连通性检查",
+			Topic:          topics.NameCommand,
+			Encoding:       EncodingJSON,
+			TimeoutSeconds: 10,
+			ResultStatuses: []string{"pong"},
+		},
+		{
+			Name:        "collect_logs",
+			Description: "收集应用日志并分片上传到 device/logs/{device_id}，服务端合并后提供下载",
+			Params: []Param{
+				{Name: "max_lines", Type: ParamInt, Description: "最多上传的日志行数", Default: 1000, Min: bound(1), Max: bound(100000)},
+				{Name: "since_minutes", Type: ParamInt, Description: "只收集最近N分钟的日志", Default: 60, Min: bound(1), Max: bound(10080)},
+			},
+			Topic:          topics.NameCommand,
+			Encoding:       EncodingJSON,
+			TimeoutSeconds: 300,
+			ResultStatuses: []string{"logs_uploaded", "logs_failed"},
+		},
+		{
+			Name:        "update_app",
+			Description: "从服务端制品库下载并安装应用新版本",
+			Params: []Param{
+				{Name: "version_code", Type: ParamInt, Description: "要安装的版本号（versionCode），默认为最新上传的版本", Min: bound(1)},
+			},
+			Topic:          topics.NameCommand,
+			Encoding:       EncodingJSON,
+			TimeoutSeconds: 600,
+			ResultStatuses: []string{"app_updated", "app_update_failed"},
+		},
+		{
+			Name:        "restart_until_new_ip",
+			Description: "反复执行重启命令直到设备换到可接受的新IP（服务端编排）",
+			Params: []Param{
+				{Name: "restart_command", Type: ParamString, Description: "每次尝试使用的重启命令（需为changes_ip命令）", Default: "restart4g"},
+				{Name: "restart_params", Type: ParamString, Description: `重启命令的参数，JSON对象，如 {"delay_seconds":5}`},
+				{Name: "max_attempts", Type: ParamInt, Description: "最多尝试次数", Default: 5, Min: bound(1), Max: bound(20)},
+				{Name: "backoff_seconds", Type: ParamInt, Description: "第二次尝试前的等待秒数", Default: 15, Min: bound(0), Max: bound(3600)},
+				{Name: "backoff_multiplier", Type: ParamFloat, Description: "每次尝试后等待时间的倍数", Default: 2.0, Min: bound(1), Max: bound(10)},
+				{Name: "blocklist", Type: ParamString, Description: "不接受的IP，逗号分隔"},
+				{Name: "blocked_prefixes", Type: ParamString, Description: "不接受的IP前缀或CIDR，逗号分隔，如 10.64.,100.64.0.0/10"},
+			},
+			Topic:     topics.NameCommand,
+			Encoding:  EncodingJSON,
+			Composite: true,
+		},
+	}
+}
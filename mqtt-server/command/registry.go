@@ -0,0 +1,312 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"mobile-admin-mqtt-server/topics"
+)
+
+// 命令载荷编码
+const (
+	EncodingJSON  = "json"  // types.MQTTMessage
+	EncodingPlain = "plain" // 纯字符串（Android客户端）
+)
+
+// 参数类型
+const (
+	ParamInt    = "int"
+	ParamFloat  = "float"
+	ParamString = "string"
+	ParamBool   = "bool"
+)
+
+// 命令参数定义
+type Param struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Min         *float64    `json:"min,omitempty"`
+	Max         *float64    `json:"max,omitempty"`
+	Enum        []string    `json:"enum,omitempty"`
+}
+
+// 命令定义
+type Spec struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Params      []Param `json:"params,omitempty"`
+	// 目标主题：主题模板名称（如 command）或自定义模板（如 device/{device_id}/ota）
+	Topic    string `json:"topic"`
+	Encoding string `json:"encoding"`
+	// 支持的设备类型，为空表示全部
+	DeviceTypes []string `json:"device_types,omitempty"`
+	// 等待设备结果的超时时间（秒）
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// 设备可能上报的结果状态
+	ResultStatuses []string `json:"result_statuses,omitempty"`
+	// 执行后设备会重新获取IP（如重启4G），服务端跟踪结果是否换到了新IP
+	ChangesIP bool `json:"changes_ip,omitempty"`
+	// 由服务端编排执行的组合命令（如 restart_until_new_ip），不直接发送给设备
+	Composite bool `json:"composite,omitempty"`
+}
+
+// 检查命令是否支持该设备类型
+func (s *Spec) SupportsDeviceType(deviceType string) bool {
+	if len(s.DeviceTypes) == 0 {
+		return true
+	}
+	for _, t := range s.DeviceTypes {
+		if t == deviceType {
+			return true
+		}
+	}
+	return false
+}
+
+// 校验参数并按类型规范化，只返回调用方传入的参数（不补全默认值，
+// 纯文本载荷据此保持与旧版客户端一致）；需要完整参数时再调用 WithDefaults
+func (s *Spec) ValidateParams(params map[string]interface{}) (map[string]interface{}, error) {
+	defined := make(map[string]bool, len(s.Params))
+	for _, p := range s.Params {
+		defined[p.Name] = true
+	}
+	for name := range params {
+		if !defined[name] {
+			return nil, fmt.Errorf("command %s: unknown parameter %s", s.Name, name)
+		}
+	}
+
+	result := make(map[string]interface{})
+	for _, p := range s.Params {
+		value, ok := params[p.Name]
+		if !ok || value == nil {
+			if p.Required {
+				return nil, fmt.Errorf("command %s: missing required parameter %s", s.Name, p.Name)
+			}
+			continue
+		}
+
+		normalized, err := p.Normalize(value)
+		if err != nil {
+			return nil, fmt.Errorf("command %s: parameter %s: %v", s.Name, p.Name, err)
+		}
+		result[p.Name] = normalized
+	}
+	return result, nil
+}
+
+// 返回补全默认值后的参数副本，用于JSON载荷、签名和服务端编排
+func (s *Spec) WithDefaults(params map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(params)+len(s.Params))
+	for name, value := range params {
+		result[name] = value
+	}
+	for _, p := range s.Params {
+		if _, ok := result[p.Name]; !ok && p.Default != nil {
+			result[p.Name] = p.Default
+		}
+	}
+	return result
+}
+
+// 按参数类型转换并校验取值范围
+func (p *Param) Normalize(value interface{}) (interface{}, error) {
+	switch p.Type {
+	case ParamInt, ParamFloat:
+		n, ok := value.(float64)
+		if !ok {
+			if i, isInt := value.(int); isInt {
+				n, ok = float64(i), true
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("expected %s, got %T", p.Type, value)
+		}
+		if p.Type == ParamInt && n != math.Trunc(n) {
+			return nil, fmt.Errorf("expected integer, got %v", n)
+		}
+		if p.Min != nil && n < *p.Min {
+			return nil, fmt.Errorf("must be >= %v", *p.Min)
+		}
+		if p.Max != nil && n > *p.Max {
+			return nil, fmt.Errorf("must be <= %v", *p.Max)
+		}
+		if p.Type == ParamInt {
+			return int(n), nil
+		}
+		return n, nil
+	case ParamString:
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %T", value)
+		}
+		if len(p.Enum) > 0 {
+			for _, e := range p.Enum {
+				if e == str {
+					return str, nil
+				}
+			}
+			return nil, fmt.Errorf("must be one of %v", p.Enum)
+		}
+		return str, nil
+	case ParamBool:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected bool, got %T", value)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported parameter type %s", p.Type)
+	}
+}
+
+// 纯字符串编码：无参数时只有命令名，与旧版客户端兼容；
+// 有参数时附加URL查询串，如 restart4g?delay_seconds=5&retry=3。
+// params 应为调用方传入的参数（ValidateParams 的结果），默认值不写入载荷
+func EncodePlain(name string, params map[string]interface{}) string {
+	if len(params) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, url.QueryEscape(k)+"="+url.QueryEscape(fmt.Sprint(params[k])))
+	}
+	return name + "?" + strings.Join(pairs, "&")
+}
+
+// 校验命令定义本身
+func (s *Spec) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("command name is required")
+	}
+	if err := topics.ValidateTemplate(s.Topic); err != nil {
+		return fmt.Errorf("command %s: topic: %v", s.Name, err)
+	}
+	if s.Encoding != EncodingJSON && s.Encoding != EncodingPlain {
+		return fmt.Errorf("command %s: unsupported encoding %s", s.Name, s.Encoding)
+	}
+	if s.TimeoutSeconds < 0 {
+		return fmt.Errorf("command %s: timeout_seconds must not be negative", s.Name)
+	}
+	for i := range s.Params {
+		p := &s.Params[i]
+		if p.Name == "" {
+			return fmt.Errorf("command %s: parameter name is required", s.Name)
+		}
+		switch p.Type {
+		case ParamInt, ParamFloat, ParamString, ParamBool:
+		default:
+			return fmt.Errorf("command %s: parameter %s has unsupported type %s", s.Name, p.Name, p.Type)
+		}
+		if p.Default != nil {
+			normalized, err := p.Normalize(p.Default)
+			if err != nil {
+				return fmt.Errorf("command %s: parameter %s default: %v", s.Name, p.Name, err)
+			}
+			p.Default = normalized
+		}
+	}
+	return nil
+}
+
+// 命令注册表
+type Registry struct {
+	specs map[string]*Spec
+	mutex sync.RWMutex
+}
+
+// 创建空的命令注册表
+func NewRegistry() *Registry {
+	return &Registry{
+		specs: make(map[string]*Spec),
+	}
+}
+
+// 创建包含内置命令的注册表
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, spec := range builtinSpecs() {
+		if err := r.Register(spec); err != nil {
+			panic(fmt.Sprintf("invalid builtin command: %v", err))
+		}
+	}
+	return r
+}
+
+// 注册命令（同名命令会被覆盖）
+func (r *Registry) Register(spec Spec) error {
+	if spec.Topic == "" {
+		spec.Topic = topics.NameCommand
+	}
+	if spec.Encoding == "" {
+		spec.Encoding = EncodingJSON
+	}
+	if err := spec.validate(); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.specs[spec.Name] = &spec
+	return nil
+}
+
+// 从JSON文件加载额外的命令定义
+func (r *Registry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read commands file: %v", err)
+	}
+
+	var specs []Spec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return fmt.Errorf("failed to parse commands file %s: %v", path, err)
+	}
+
+	for _, spec := range specs {
+		if err := r.Register(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 获取命令定义
+func (r *Registry) Get(name string) (*Spec, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	spec, ok := r.specs[name]
+	return spec, ok
+}
+
+// 获取所有命令定义（按名称排序）
+func (r *Registry) All() []*Spec {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	specs := make([]*Spec, 0, len(r.specs))
+	for _, spec := range r.specs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+	return specs
+}
@@ -0,0 +1,315 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"mobile-admin-mqtt-server/logging"
+	"mobile-admin-mqtt-server/signing"
+	"mobile-admin-mqtt-server/topics"
+	"mobile-admin-mqtt-server/tracing"
+	"mobile-admin-mqtt-server/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 服务端配置，可来自配置文件、环境变量和命令行参数
+type Config struct {
+	MQTT         MQTT       `yaml:"mqtt"`
+	HTTP         HTTP       `yaml:"http"`
+	Log          Log        `yaml:"log"`
+	Devices      Devices    `yaml:"devices"`
+	Auth         Auth       `yaml:"auth"`
+	TenantsFile  string     `yaml:"tenants_file"`
+	CommandsFile string     `yaml:"commands_file"`
+	Enrollment   Enrollment `yaml:"enrollment"`
+	Signing      Signing    `yaml:"signing"`
+	DeviceLogs   DeviceLogs `yaml:"device_logs"`
+	Artifacts    Artifacts  `yaml:"artifacts"`
+	Tracing      Tracing    `yaml:"tracing"`
+	// 关闭时等待处理中的HTTP请求和换IP任务的最长时间
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// MQTT broker 连接和主题配置
+type MQTT struct {
+	Broker      string `yaml:"broker"`
+	Port        int    `yaml:"port"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	TopicPrefix string `yaml:"topic_prefix"`
+	// 按名称覆盖的主题模板（如 command: "cmd/{device_id}"）
+	Topics map[string]string `yaml:"topics"`
+	// 输出MQTT客户端库的调试日志
+	Debug bool `yaml:"debug"`
+}
+
+// HTTP API 配置
+type HTTP struct {
+	Port string `yaml:"port"`
+	// 设备访问本服务HTTP API的地址，用于生成下载链接
+	PublicURL string `yaml:"public_url"`
+}
+
+// 日志配置
+type Log struct {
+	// 默认级别：trace、debug、info、warn、error（trace 逐条记录MQTT消息）
+	Level string `yaml:"level"`
+	// 按子系统覆盖级别，如 mqtt: trace、http: warn
+	Levels map[string]string `yaml:"levels"`
+	// text 或 json
+	Format string `yaml:"format"`
+	// 日志同时追加写入的文件，为空时只输出到标准错误
+	File string `yaml:"file"`
+	// 日志文件超过该大小（MB）时轮转，0表示不轮转
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// 保留的轮转文件数
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// 设备管理配置
+type Devices struct {
+	// 租户未配置 max_devices 时的设备数上限（0表示不限制）
+	MaxDevices int `yaml:"max_devices"`
+	// 超过该时长未活动视为离线
+	Timeout time.Duration `yaml:"timeout"`
+	// 超过该时长未活动则从设备表移除
+	RemoveAfter time.Duration `yaml:"remove_after"`
+	// 保存设备配置影子的JSON文件，为空时只保存在内存中
+	ConfigFile string `yaml:"config_file"`
+}
+
+// 单租户模式的API凭证
+type Auth struct {
+	Enabled bool     `yaml:"enabled"`
+	APIKeys []string `yaml:"api_keys"`
+}
+
+// 设备注册准入配置
+type Enrollment struct {
+	Mode string `yaml:"mode"`
+	File string `yaml:"file"`
+}
+
+// 命令签名配置
+type Signing struct {
+	Mode    string        `yaml:"mode"`
+	TTL     time.Duration `yaml:"ttl"`
+	KeyFile string        `yaml:"key_file"`
+}
+
+// 设备日志收集配置
+type DeviceLogs struct {
+	Dir       string        `yaml:"dir"`
+	MaxMB     int           `yaml:"max_mb"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+// 应用制品库配置
+type Artifacts struct {
+	Dir   string `yaml:"dir"`
+	MaxMB int    `yaml:"max_mb"`
+}
+
+// 请求追踪（OpenTelemetry）配置
+type Tracing struct {
+	// none、stdout 或 otlp
+	Exporter string `yaml:"exporter"`
+	// OTLP/HTTP 接收地址，如 http://localhost:4318
+	Endpoint string `yaml:"endpoint"`
+	// 新追踪的采样比例（0-1）
+	SampleRatio float64 `yaml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name"`
+}
+
+// 默认配置
+func Default() *Config {
+	return &Config{
+		MQTT: MQTT{
+			Broker: "121.199.162.193",
+			Port:   1888,
+		},
+		HTTP: HTTP{Port: "8080"},
+		Log: Log{
+			Level:      "info",
+			Format:     logging.FormatText,
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+		},
+		Devices: Devices{
+			Timeout:     5 * time.Minute,
+			RemoveAfter: 10 * time.Minute,
+		},
+		Enrollment: Enrollment{Mode: types.EnrollmentOpen},
+		Signing: Signing{
+			Mode: types.SigningOff,
+			TTL:  signing.DefaultTTL,
+		},
+		DeviceLogs: DeviceLogs{
+			Dir:       "device-logs",
+			MaxMB:     10,
+			Retention: 7 * 24 * time.Hour,
+		},
+		Artifacts: Artifacts{
+			Dir:   "artifacts",
+			MaxMB: 200,
+		},
+		Tracing: Tracing{
+			Exporter:    tracing.ExporterNone,
+			SampleRatio: 1,
+			ServiceName: "mqtt-server",
+		},
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// 从YAML文件读取配置，覆盖c中的对应字段；未知字段视为错误以便发现拼写错误
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// 校验配置，一次返回所有问题
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.MQTT.Broker == "" {
+		add("mqtt.broker: must not be empty")
+	}
+	if c.MQTT.Port < 1 || c.MQTT.Port > 65535 {
+		add("mqtt.port: must be between 1 and 65535, got %d", c.MQTT.Port)
+	}
+	if _, err := topics.NewTree(c.MQTT.TopicPrefix, c.MQTT.Topics); err != nil {
+		add("mqtt.topics: %v", err)
+	}
+
+	if c.HTTP.Port == "" {
+		add("http.port: must not be empty")
+	}
+	if c.HTTP.PublicURL != "" {
+		if u, err := url.Parse(c.HTTP.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("http.public_url: must be an absolute http(s) URL, got %q", c.HTTP.PublicURL)
+		}
+	}
+
+	if err := c.Logging().Validate(); err != nil {
+		add("log: %v", err)
+	}
+
+	if c.Devices.MaxDevices < 0 {
+		add("devices.max_devices: must not be negative, got %d", c.Devices.MaxDevices)
+	}
+	if c.Devices.Timeout < time.Minute {
+		add("devices.timeout: must be at least 1m, got %s", c.Devices.Timeout)
+	}
+	if c.Devices.RemoveAfter <= c.Devices.Timeout {
+		add("devices.remove_after: must be longer than devices.timeout (%s), got %s", c.Devices.Timeout, c.Devices.RemoveAfter)
+	}
+
+	if c.Auth.Enabled && len(c.Auth.APIKeys) == 0 {
+		add("auth.api_keys: at least one key is required when auth.enabled is true")
+	}
+	if c.Auth.Enabled && c.TenantsFile != "" {
+		add("auth.enabled: not supported with tenants_file, set api_key on each tenant instead")
+	}
+	seen := make(map[string]bool)
+	for i, key := range c.Auth.APIKeys {
+		if key == "" {
+			add("auth.api_keys[%d]: must not be empty", i)
+		} else if seen[key] {
+			add("auth.api_keys[%d]: duplicate key", i)
+		}
+		seen[key] = true
+	}
+
+	switch c.Enrollment.Mode {
+	case types.EnrollmentOpen, types.EnrollmentAllowlist, types.EnrollmentQuarantine:
+	default:
+		add("enrollment.mode: must be %s, %s or %s, got %q", types.EnrollmentOpen, types.EnrollmentAllowlist, types.EnrollmentQuarantine, c.Enrollment.Mode)
+	}
+
+	switch c.Signing.Mode {
+	case types.SigningOff, types.SigningJSON, types.SigningAll:
+	default:
+		add("signing.mode: must be %s, %s or %s, got %q", types.SigningOff, types.SigningJSON, types.SigningAll, c.Signing.Mode)
+	}
+	if c.Signing.TTL <= 0 {
+		add("signing.ttl: must be positive, got %s", c.Signing.TTL)
+	}
+
+	if c.DeviceLogs.Dir == "" {
+		add("device_logs.dir: must not be empty")
+	}
+	if c.DeviceLogs.MaxMB <= 0 {
+		add("device_logs.max_mb: must be positive, got %d", c.DeviceLogs.MaxMB)
+	}
+	if c.DeviceLogs.Retention < 0 {
+		add("device_logs.retention: must not be negative, got %s", c.DeviceLogs.Retention)
+	}
+
+	if c.Artifacts.Dir == "" {
+		add("artifacts.dir: must not be empty")
+	}
+	if c.Artifacts.MaxMB <= 0 {
+		add("artifacts.max_mb: must be positive, got %d", c.Artifacts.MaxMB)
+	}
+
+	if err := c.TracingOptions().Validate(); err != nil {
+		add("tracing: %v", err)
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		add("shutdown_timeout: must be positive, got %s", c.ShutdownTimeout)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// 日志选项；mqtt.debug 开启时MQTT客户端库（paho）至少输出debug日志
+func (c *Config) Logging() logging.Options {
+	levels := make(map[string]string, len(c.Log.Levels)+1)
+	for name, level := range c.Log.Levels {
+		levels[name] = level
+	}
+	if _, ok := levels["paho"]; !ok && c.MQTT.Debug {
+		levels["paho"] = "debug"
+	}
+	return logging.Options{
+		Format:     c.Log.Format,
+		Level:      c.Log.Level,
+		Levels:     levels,
+		File:       c.Log.File,
+		MaxSizeMB:  c.Log.MaxSizeMB,
+		MaxBackups: c.Log.MaxBackups,
+	}
+}
+
+// 追踪选项
+func (c *Config) TracingOptions() tracing.Options {
+	return tracing.Options{
+		Exporter:    c.Tracing.Exporter,
+		Endpoint:    c.Tracing.Endpoint,
+		SampleRatio: c.Tracing.SampleRatio,
+		ServiceName: c.Tracing.ServiceName,
+	}
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mobile-admin-mqtt-server/topics"
+)
+
+// 配置项：配置文件中的路径及对应的环境变量、命令行参数
+type Setting struct {
+	// 配置文件中的路径，如 mqtt.broker
+	Key   string
+	Env   string
+	Flag  string
+	Usage string
+	// 可通过 SIGHUP 重新加载，无需重启
+	Reloadable bool
+
+	field func(c *Config) interface{}
+}
+
+// 所有配置项（mqtt.topics 另外通过 MQTT_TOPIC_<NAME> 环境变量覆盖）
+var Settings = []Setting{
+	{Key: "mqtt.broker", Env: "MQTT_BROKER", Flag: "broker", Usage: "MQTT broker hostname", field: func(c *Config) interface{} { return &c.MQTT.Broker }},
+	{Key: "mqtt.port", Env: "MQTT_PORT", Flag: "port", Usage: "MQTT broker port", field: func(c *Config) interface{} { return &c.MQTT.Port }},
+	{Key: "mqtt.username", Env: "MQTT_USERNAME", Flag: "username", Usage: "MQTT username", field: func(c *Config) interface{} { return &c.MQTT.Username }},
+	{Key: "mqtt.password", Env: "MQTT_PASSWORD", Flag: "password", Usage: "MQTT password", field: func(c *Config) interface{} { return &c.MQTT.Password }},
+	{Key: "mqtt.topic_prefix", Env: "MQTT_TOPIC_PREFIX", Flag: "topic-prefix", Usage: "MQTT topic root prefix (e.g. tenantA/m4g)", field: func(c *Config) interface{} { return &c.MQTT.TopicPrefix }},
+	{Key: "mqtt.topics", field: func(c *Config) interface{} { return &c.MQTT.Topics }},
+	{Key: "mqtt.debug", Env: "MQTT_DEBUG", Flag: "mqtt-debug", Usage: "Log MQTT client library debug output", Reloadable: true, field: func(c *Config) interface{} { return &c.MQTT.Debug }},
+	{Key: "http.port", Env: "HTTP_PORT", Flag: "http-port", Usage: "HTTP API server port", field: func(c *Config) interface{} { return &c.HTTP.Port }},
+	{Key: "http.public_url", Env: "PUBLIC_URL", Flag: "public-url", Usage: "Base URL devices use to reach this server's HTTP API (e.g. http://mqtt.example.com:8080)", field: func(c *Config) interface{} { return &c.HTTP.PublicURL }},
+	{Key: "log.level", Env: "LOG_LEVEL", Flag: "log-level", Usage: "Default log level: trace, debug, info, warn or error", Reloadable: true, field: func(c *Config) interface{} { return &c.Log.Level }},
+	{Key: "log.levels", Env: "LOG_LEVELS", Flag: "log-levels", Usage: "Per-subsystem log levels (e.g. mqtt=trace,http=warn)", Reloadable: true, field: func(c *Config) interface{} { return &c.Log.Levels }},
+	{Key: "log.format", Env: "LOG_FORMAT", Flag: "log-format", Usage: "Log format: text or json", Reloadable: true, field: func(c *Config) interface{} { return &c.Log.Format }},
+	{Key: "log.file", Env: "LOG_FILE", Flag: "log-file", Usage: "Also append logs to this file (reopened on SIGHUP)", Reloadable: true, field: func(c *Config) interface{} { return &c.Log.File }},
+	{Key: "log.max_size_mb", Env: "LOG_MAX_SIZE_MB", Flag: "log-max-size-mb", Usage: "Rotate the log file when it exceeds this size in MB (0 disables rotation)", Reloadable: true, field: func(c *Config) interface{} { return &c.Log.MaxSizeMB }},
+	{Key: "log.max_backups", Env: "LOG_MAX_BACKUPS", Flag: "log-max-backups", Usage: "Number of rotated log files to keep", Reloadable: true, field: func(c *Config) interface{} { return &c.Log.MaxBackups }},
+	{Key: "devices.max_devices", Env: "MAX_DEVICES", Flag: "max-devices", Usage: "Device quota for tenants without max_devices (0 means unlimited)", Reloadable: true, field: func(c *Config) interface{} { return &c.Devices.MaxDevices }},
+	{Key: "devices.timeout", Env: "DEVICE_TIMEOUT", Flag: "device-timeout", Usage: "Inactivity after which a device is marked offline", Reloadable: true, field: func(c *Config) interface{} { return &c.Devices.Timeout }},
+	{Key: "devices.remove_after", Env: "DEVICE_REMOVE_AFTER", Flag: "device-remove-after", Usage: "Inactivity after which a device is removed", Reloadable: true, field: func(c *Config) interface{} { return &c.Devices.RemoveAfter }},
+	{Key: "devices.config_file", Env: "DEVICE_CONFIG_FILE", Flag: "device-config-file", Usage: "JSON file storing device config shadows (desired/reported)", field: func(c *Config) interface{} { return &c.Devices.ConfigFile }},
+	{Key: "auth.enabled", Env: "ENABLE_AUTH", Flag: "enable-auth", Usage: "Require an API key in single-tenant mode", Reloadable: true, field: func(c *Config) interface{} { return &c.Auth.Enabled }},
+	{Key: "auth.api_keys", Env: "API_KEYS", Usage: "Comma separated API keys for single-tenant mode", Reloadable: true, field: func(c *Config) interface{} { return &c.Auth.APIKeys }},
+	{Key: "tenants_file", Env: "TENANTS_FILE", Flag: "tenants", Usage: "Tenants JSON file (multi-tenant mode)", field: func(c *Config) interface{} { return &c.TenantsFile }},
+	{Key: "commands_file", Env: "COMMANDS_FILE", Flag: "commands", Usage: "Additional command definitions JSON file", field: func(c *Config) interface{} { return &c.CommandsFile }},
+	{Key: "enrollment.mode", Env: "ENROLLMENT_MODE", Flag: "enrollment", Usage: "Default device enrollment mode: open, allowlist or quarantine", field: func(c *Config) interface{} { return &c.Enrollment.Mode }},
+	{Key: "enrollment.file", Env: "ENROLLMENT_FILE", Flag: "enrollment-file", Usage: "JSON file storing provisioned and pending devices", field: func(c *Config) interface{} { return &c.Enrollment.File }},
+	{Key: "signing.mode", Env: "COMMAND_SIGNING", Flag: "command-signing", Usage: "Command signing mode: off, json or all", field: func(c *Config) interface{} { return &c.Signing.Mode }},
+	{Key: "signing.ttl", Env: "COMMAND_TTL", Flag: "command-ttl", Usage: "Validity of signed commands", field: func(c *Config) interface{} { return &c.Signing.TTL }},
+	{Key: "signing.key_file", Env: "SIGNING_KEY_FILE", Flag: "signing-key-file", Usage: "JSON file storing command signing keys", field: func(c *Config) interface{} { return &c.Signing.KeyFile }},
+	{Key: "device_logs.dir", Env: "DEVICE_LOG_DIR", Flag: "device-log-dir", Usage: "Directory storing logs uploaded by devices", field: func(c *Config) interface{} { return &c.DeviceLogs.Dir }},
+	{Key: "device_logs.max_mb", Env: "DEVICE_LOG_MAX_MB", Flag: "device-log-max-mb", Usage: "Maximum size of one uploaded log bundle in MB", field: func(c *Config) interface{} { return &c.DeviceLogs.MaxMB }},
+	{Key: "device_logs.retention", Env: "DEVICE_LOG_RETENTION", Flag: "device-log-retention", Usage: "How long uploaded log bundles are kept (0 keeps them until replaced)", Reloadable: true, field: func(c *Config) interface{} { return &c.DeviceLogs.Retention }},
+	{Key: "artifacts.dir", Env: "ARTIFACT_DIR", Flag: "artifact-dir", Usage: "Directory storing uploaded APKs", field: func(c *Config) interface{} { return &c.Artifacts.Dir }},
+	{Key: "artifacts.max_mb", Env: "ARTIFACT_MAX_MB", Flag: "artifact-max-mb", Usage: "Maximum size of one uploaded APK in MB", field: func(c *Config) interface{} { return &c.Artifacts.MaxMB }},
+	{Key: "tracing.exporter", Env: "TRACING_EXPORTER", Flag: "tracing-exporter", Usage: "Trace exporter: none, stdout or otlp", field: func(c *Config) interface{} { return &c.Tracing.Exporter }},
+	{Key: "tracing.endpoint", Env: "TRACING_ENDPOINT", Flag: "tracing-endpoint", Usage: "OTLP/HTTP endpoint URL (e.g. http://localhost:4318)", field: func(c *Config) interface{} { return &c.Tracing.Endpoint }},
+	{Key: "tracing.sample_ratio", Env: "TRACING_SAMPLE_RATIO", Flag: "tracing-sample-ratio", Usage: "Fraction of new traces to sample (0-1)", field: func(c *Config) interface{} { return &c.Tracing.SampleRatio }},
+	{Key: "tracing.service_name", Env: "TRACING_SERVICE_NAME", Flag: "tracing-service-name", Usage: "Service name reported with traces", field: func(c *Config) interface{} { return &c.Tracing.ServiceName }},
+	{Key: "shutdown_timeout", Env: "SHUTDOWN_TIMEOUT", Flag: "shutdown-timeout", Usage: "How long shutdown waits for in-flight HTTP requests and rotation jobs", field: func(c *Config) interface{} { return &c.ShutdownTimeout }},
+}
+
+// 按字符串设置配置项的值；时长可写作 90s、5m，纯数字按秒计；列表以逗号分隔，映射写作 name=value,...
+func (s Setting) set(c *Config, value string) error {
+	switch p := s.field(c).(type) {
+	case *string:
+		*p = value
+	case *int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*p = b
+	case *float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*p = f
+	case *time.Duration:
+		value = strings.TrimSpace(value)
+		if n, err := strconv.Atoi(value); err == nil {
+			*p = time.Duration(n) * time.Second
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q (use e.g. 90s, 5m or a number of seconds)", value)
+		}
+		*p = d
+	case *[]string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*p = list
+	case *map[string]string:
+		m := make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(item, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				return fmt.Errorf("invalid entry %q (use name=value pairs separated by commas)", item)
+			}
+			m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		*p = m
+	default:
+		return fmt.Errorf("%s cannot be set from a string", s.Key)
+	}
+	return nil
+}
+
+// 格式化配置项的值，用于命令行帮助中的默认值
+func (s Setting) format(c *Config) string {
+	switch p := s.field(c).(type) {
+	case *[]string:
+		return strings.Join(*p, ",")
+	case *map[string]string:
+		pairs := make([]string, 0, len(*p))
+		for k, v := range *p {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(reflect.ValueOf(p).Elem().Interface())
+	}
+}
+
+// 命令行参数的值：只记录显式传入的参数，加载时再覆盖到配置上
+type flagValue struct {
+	setting Setting
+	def     string
+	values  map[string]string
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(value string) error {
+	// 提前校验，使错误在解析命令行时报告
+	if err := f.setting.set(Default(), value); err != nil {
+		return err
+	}
+	f.values[f.setting.Key] = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	_, ok := f.setting.field(Default()).(*bool)
+	return ok
+}
+
+// 配置加载器：按 命令行参数 > 环境变量 > 配置文件 > 默认值 合并配置，SIGHUP 时用相同的来源重新加载
+type Loader struct {
+	path   *string
+	flags  map[string]string
+	lookup func(string) (string, bool)
+}
+
+// 在fs上注册 -config 和所有配置项对应的命令行参数
+func RegisterFlags(fs *flag.FlagSet) *Loader {
+	l := &Loader{
+		flags:  make(map[string]string),
+		lookup: os.LookupEnv,
+	}
+	l.path = fs.String("config", "", "YAML config file (env CONFIG_FILE)")
+
+	defaults := Default()
+	for _, s := range Settings {
+		if s.Flag == "" {
+			continue
+		}
+		fs.Var(&flagValue{setting: s, def: s.format(defaults), values: l.flags}, s.Flag, s.Usage+" (env "+s.Env+")")
+	}
+	return l
+}
+
+// 配置文件路径，未指定时为空
+func (l *Loader) Path() string {
+	if *l.path != "" {
+		return *l.path
+	}
+	path, _ := l.lookup("CONFIG_FILE")
+	return path
+}
+
+// 加载并校验配置
+func (l *Loader) Load() (*Config, error) {
+	c := Default()
+
+	if path := l.Path(); path != "" {
+		if err := c.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range Settings {
+		if s.Env == "" {
+			continue
+		}
+		if value, ok := l.lookup(s.Env); ok && value != "" {
+			if err := s.set(c, value); err != nil {
+				return nil, fmt.Errorf("environment variable %s (%s): %v", s.Env, s.Key, err)
+			}
+		}
+	}
+	// 按名称覆盖主题模板（MQTT_TOPIC_<NAME>，如 MQTT_TOPIC_COMMAND）
+	for name := range topics.DefaultTemplates() {
+		if value, ok := l.lookup("MQTT_TOPIC_" + strings.ToUpper(name)); ok && value != "" {
+			if c.MQTT.Topics == nil {
+				c.MQTT.Topics = make(map[string]string)
+			}
+			c.MQTT.Topics[name] = value
+		}
+	}
+
+	for _, s := range Settings {
+		if value, ok := l.flags[s.Key]; ok {
+			if err := s.set(c, value); err != nil {
+				return nil, fmt.Errorf("flag -%s (%s): %v", s.Flag, s.Key, err)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 比较两份配置，返回值不同的配置项
+func Changes(old, new *Config) []Setting {
+	var changed []Setting
+	for _, s := range Settings {
+		a := reflect.ValueOf(s.field(old)).Elem().Interface()
+		b := reflect.ValueOf(s.field(new)).Elem().Interface()
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, s)
+		}
+	}
+	return changed
+}
+
+// 重新加载时保留需要重启才能生效的配置项：将其从cur复制到next，并返回这些值被修改过的配置项
+func KeepRestartOnly(cur, next *Config) []Setting {
+	var kept []Setting
+	for _, s := range Changes(cur, next) {
+		if !s.Reloadable {
+			reflect.ValueOf(s.field(next)).Elem().Set(reflect.ValueOf(s.field(cur)).Elem())
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
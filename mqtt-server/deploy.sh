@@ -308,7 +308,11 @@ stop_existing_server() {
         if ps -p $PID > /dev/null; then
             echo -e "${YELLOW}停止已运行的服务器 (PID: $PID)...${NC}"
             kill $PID
-            sleep 2
+            # 等待服务器处理完进行中的请求和任务后退出（最长 SHUTDOWN_TIMEOUT，默认30秒）
+            for i in $(seq 1 40); do
+                ps -p $PID > /dev/null || break
+                sleep 1
+            done
             rm -f mqtt-server.pid
         fi
     fi
@@ -0,0 +1,124 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/topics"
+	"mobile-admin-mqtt-server/types"
+)
+
+// 设备协议适配器：一个设备族如何编码命令、使用哪些主题、如何解析上报的状态
+type Adapter interface {
+	// 适配器名称，注册时记录在 Device.Protocol 中
+	Name() string
+	// 适配器负责的设备类型，从状态主题自动注册设备时按 device_type 选择适配器
+	DeviceTypes() []string
+	// 设备上报纯文本状态的主题（模板名称或自定义模板），为空表示只使用标准JSON状态主题
+	StatusTopic() string
+	// 该协议的客户端能执行的命令，nil 表示不限；未声明能力的设备注册时以此作为能力
+	Commands() []string
+	// 编码命令，返回目标主题和载荷
+	EncodeCommand(tree *topics.Tree, device *types.Device, spec *command.Spec, params map[string]interface{}) (string, []byte, error)
+	// 解析设备上报的状态字符串，返回规范化状态和附加说明
+	ParseStatus(raw string) (status, detail string)
+}
+
+// 内置适配器，由各设备族文件在init中登记
+var builtinAdapters []Adapter
+
+// 登记内置适配器，新增设备族只需新增一个适配器文件
+func registerBuiltin(adapter Adapter) {
+	builtinAdapters = append(builtinAdapters, adapter)
+}
+
+func init() {
+	registerBuiltin(jsonAdapter{})
+	registerBuiltin(&androidFamily{
+		name:         types.ProtocolAndroid,
+		fallbackType: types.DeviceTypeOPPO,
+	})
+}
+
+// 标准JSON协议：按命令定义的主题和编码发布 types.MQTTMessage
+type jsonAdapter struct{}
+
+func (jsonAdapter) Name() string {
+	return types.ProtocolJSON
+}
+
+func (jsonAdapter) DeviceTypes() []string {
+	return []string{types.DeviceTypeGeneric}
+}
+
+func (jsonAdapter) StatusTopic() string {
+	return ""
+}
+
+func (jsonAdapter) Commands() []string {
+	return nil
+}
+
+func (jsonAdapter) EncodeCommand(tree *topics.Tree, device *types.Device, spec *command.Spec, params map[string]interface{}) (string, []byte, error) {
+	topic := tree.Format(spec.Topic, map[string]string{
+		topics.VarDeviceID:   device.ID,
+		topics.VarDeviceType: deviceType(device, types.DeviceTypeGeneric),
+		topics.VarCommand:    spec.Name,
+	})
+
+	if spec.Encoding == command.EncodingPlain {
+		return topic, []byte(command.EncodePlain(spec.Name, params)), nil
+	}
+
+	// 构建命令消息，参数（含默认值）放在Data中
+	msg := types.MQTTMessage{
+		Action:    "command",
+		Command:   spec.Name,
+		Timestamp: time.Now().Unix(),
+		DeviceID:  device.ID,
+	}
+	if full := spec.WithDefaults(params); len(full) > 0 {
+		msg.Data = full
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal command message: %v", err)
+	}
+	return topic, payload, nil
+}
+
+func (jsonAdapter) ParseStatus(raw string) (string, string) {
+	return parseStatus(raw, nil)
+}
+
+// 获取设备类型，未上报时使用默认值
+func deviceType(device *types.Device, fallback string) string {
+	if device.DeviceInfo != nil {
+		if dt, ok := device.DeviceInfo["device_type"]; ok && dt != "" {
+			return dt
+		}
+	}
+	return fallback
+}
+
+// 拆分 "状态: 说明" 格式的状态字符串，并按别名表映射为规范状态；
+// 不在别名表中的状态原样保留（小写）
+func parseStatus(raw string, aliases map[string]string) (string, string) {
+	code, detail := raw, ""
+	if i := strings.Index(raw, ":"); i >= 0 {
+		code, detail = raw[:i], strings.TrimSpace(raw[i+1:])
+	}
+
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return types.StatusUnknown, detail
+	}
+	if status, ok := aliases[code]; ok {
+		return status, detail
+	}
+	return code, detail
+}
@@ -0,0 +1,17 @@
+package device
+
+import "mobile-admin-mqtt-server/types"
+
+// 华为/荣耀（EMUI/HarmonyOS）：只能切换飞行模式，上报飞行模式和网络恢复事件
+func init() {
+	registerBuiltin(&androidFamily{
+		name:         types.DeviceTypeHuawei,
+		deviceTypes:  []string{types.DeviceTypeHuawei, "honor"},
+		fallbackType: types.DeviceTypeHuawei,
+		aliases: map[string]string{
+			"airplane_mode_on": types.StatusRestarting,
+			"network_restored": types.StatusRestartSuccess,
+			"network_lost":     types.StatusDisconnected,
+		},
+	})
+}
@@ -0,0 +1,62 @@
+package device
+
+import (
+	"strings"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/topics"
+	"mobile-admin-mqtt-server/types"
+)
+
+func init() {
+	registerBuiltin(linuxModemAdapter{})
+}
+
+// ModemManager 调制解调器状态 -> 规范化状态
+var modemStates = map[string]string{
+	"connected":     types.StatusConnected,
+	"connecting":    types.StatusConnecting,
+	"registered":    types.StatusConnecting,
+	"searching":     types.StatusConnecting,
+	"enabling":      types.StatusConnecting,
+	"enabled":       types.StatusConnecting,
+	"initializing":  types.StatusConnecting,
+	"disconnecting": types.StatusDisconnected,
+	"disabling":     types.StatusDisconnected,
+	"disabled":      types.StatusDisconnected,
+	"locked":        types.StatusDisconnected,
+	"failed":        types.StatusDisconnected,
+}
+
+// Linux 4G模块/路由器（OpenWrt、ModemManager等）：命令使用标准JSON协议，
+// 状态以ModemManager的状态名纯文本上报到 modem/{device_id}/state
+type linuxModemAdapter struct{}
+
+func (linuxModemAdapter) Name() string {
+	return types.DeviceTypeLinuxModem
+}
+
+func (linuxModemAdapter) DeviceTypes() []string {
+	return []string{types.DeviceTypeLinuxModem}
+}
+
+func (linuxModemAdapter) StatusTopic() string {
+	return topics.NameModemState
+}
+
+func (linuxModemAdapter) Commands() []string {
+	return nil
+}
+
+func (linuxModemAdapter) EncodeCommand(tree *topics.Tree, device *types.Device, spec *command.Spec, params map[string]interface{}) (string, []byte, error) {
+	return jsonAdapter{}.EncodeCommand(tree, device, spec, params)
+}
+
+func (linuxModemAdapter) ParseStatus(raw string) (string, string) {
+	status, detail := parseStatus(raw, modemStates)
+	// 多个调制解调器状态映射到同一规范状态，未附带说明时保留原状态名
+	if state := strings.ToLower(strings.TrimSpace(raw)); detail == "" && status != state {
+		detail = state
+	}
+	return status, detail
+}
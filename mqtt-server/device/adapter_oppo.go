@@ -0,0 +1,12 @@
+package device
+
+import "mobile-admin-mqtt-server/types"
+
+// OPPO（ColorOS）：通过Shizuku切换飞行模式重启4G，上报的就是规范化状态
+func init() {
+	registerBuiltin(&androidFamily{
+		name:         types.DeviceTypeOPPO,
+		deviceTypes:  []string{types.DeviceTypeOPPO},
+		fallbackType: types.DeviceTypeOPPO,
+	})
+}
@@ -0,0 +1,17 @@
+package device
+
+import "mobile-admin-mqtt-server/types"
+
+// 三星（One UI）：通过Knox策略开关数据，Knox拒绝时上报 knox_denied
+func init() {
+	registerBuiltin(&androidFamily{
+		name:         types.DeviceTypeSamsung,
+		deviceTypes:  []string{types.DeviceTypeSamsung},
+		fallbackType: types.DeviceTypeSamsung,
+		aliases: map[string]string{
+			"data_on":     types.StatusConnected,
+			"data_off":    types.StatusDisconnected,
+			"knox_denied": types.StatusRestartFailed,
+		},
+	})
+}
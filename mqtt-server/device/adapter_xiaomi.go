@@ -0,0 +1,17 @@
+package device
+
+import "mobile-admin-mqtt-server/types"
+
+// 小米（MIUI/HyperOS）：客户端直接开关移动数据，上报移动数据开关状态
+func init() {
+	registerBuiltin(&androidFamily{
+		name:         types.DeviceTypeXiaomi,
+		deviceTypes:  []string{types.DeviceTypeXiaomi, "redmi", "poco"},
+		fallbackType: types.DeviceTypeXiaomi,
+		aliases: map[string]string{
+			"mobile_data_on":         types.StatusConnected,
+			"mobile_data_off":        types.StatusDisconnected,
+			"mobile_data_restarting": types.StatusRestarting,
+		},
+	})
+}
@@ -0,0 +1,121 @@
+package device
+
+import (
+	"fmt"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// 运维人员维护的设备元数据，与设备记录分开保存（受mutex保护），
+// 设备因长时间未活动被移除后重新注册时仍能恢复
+type metadata struct {
+	name          string
+	location      string
+	notes         string
+	infoOverrides map[string]string
+}
+
+// 将保存的元数据应用到（重新）注册的设备上，调用方需持有锁
+func (m *Manager) applyMetadata(device *types.Device) {
+	md, ok := m.metadata[device.TenantID][device.ID]
+	if !ok {
+		return
+	}
+	device.Name = md.name
+	device.Location = md.location
+	device.Notes = md.notes
+	device.InfoOverrides = md.infoOverrides
+	applyInfoOverrides(device)
+}
+
+// 保存设备当前的元数据，调用方需持有锁
+func (m *Manager) saveMetadata(device *types.Device) {
+	partition, ok := m.metadata[device.TenantID]
+	if !ok {
+		partition = make(map[string]*metadata)
+		m.metadata[device.TenantID] = partition
+	}
+	partition[device.ID] = &metadata{
+		name:          device.Name,
+		location:      device.Location,
+		notes:         device.Notes,
+		infoOverrides: device.InfoOverrides,
+	}
+}
+
+// 将运维人员设置的设备信息覆盖项合并到设备信息中（复制后修改，不影响调用方的map），调用方需持有锁
+func applyInfoOverrides(device *types.Device) {
+	if len(device.InfoOverrides) == 0 {
+		return
+	}
+	info := make(map[string]string, len(device.DeviceInfo)+len(device.InfoOverrides))
+	for key, value := range device.DeviceInfo {
+		info[key] = value
+	}
+	for key, value := range device.InfoOverrides {
+		info[key] = value
+	}
+	device.DeviceInfo = info
+}
+
+// 更新运维人员维护的设备元数据，或手动将设备标记为离线，返回更新后的设备副本
+func (m *Manager) UpdateDevice(tenantID, deviceID string, update *types.DeviceUpdate) (*types.Device, error) {
+	if update.IsOnline != nil && *update.IsOnline {
+		return nil, fmt.Errorf("is_online can only be set to false; devices come online by registering")
+	}
+	for key := range update.DeviceInfo {
+		if key == "" {
+			return nil, fmt.Errorf("device_info keys must not be empty")
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	device, exists := m.lookup(tenantID, deviceID)
+	if !exists {
+		return nil, fmt.Errorf("device not found: %s", deviceID)
+	}
+
+	if update.Name != nil {
+		device.Name = *update.Name
+	}
+	if update.Location != nil {
+		device.Location = *update.Location
+	}
+	if update.Notes != nil {
+		device.Notes = *update.Notes
+	}
+
+	if len(update.DeviceInfo) > 0 {
+		overrides := make(map[string]string, len(device.InfoOverrides))
+		for key, value := range device.InfoOverrides {
+			overrides[key] = value
+		}
+		info := make(map[string]string, len(device.DeviceInfo))
+		for key, value := range device.DeviceInfo {
+			info[key] = value
+		}
+		// 删除覆盖项时同时移除当前值，设备下次注册时会重新上报
+		for key, value := range update.DeviceInfo {
+			if value == nil {
+				delete(overrides, key)
+				delete(info, key)
+				continue
+			}
+			overrides[key] = *value
+			info[key] = *value
+		}
+		device.InfoOverrides = overrides
+		device.DeviceInfo = info
+	}
+	m.saveMetadata(device)
+
+	if update.IsOnline != nil && device.IsOnline {
+		m.markOffline(device)
+	} else {
+		m.publishState(device)
+	}
+	logger.Info("Device updated by operator", "tenant_id", tenantID, "device_id", deviceID)
+	return cloneDevice(device), nil
+}
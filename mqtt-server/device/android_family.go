@@ -0,0 +1,54 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/topics"
+	"mobile-admin-mqtt-server/types"
+)
+
+// Android客户端（app/）只订阅 device/{device_type}/restart4g，也只处理该命令
+var androidCommands = []string{"restart4g"}
+
+// Android客户端设备族：命令发布到 device/{device_type}/{command}，载荷为纯字符串；
+// 状态以纯文本上报到 device/{device_type}/status，如 "4g_restart_failed: 权限不足"。
+// 各品牌只在设备类型和ROM特有的状态字符串上有差异
+type androidFamily struct {
+	name string
+	// 负责的设备类型
+	deviceTypes []string
+	// 设备信息中没有设备类型时使用
+	fallbackType string
+	// 品牌特有状态 -> 规范化状态
+	aliases map[string]string
+}
+
+func (a *androidFamily) Name() string {
+	return a.name
+}
+
+func (a *androidFamily) DeviceTypes() []string {
+	return a.deviceTypes
+}
+
+func (a *androidFamily) StatusTopic() string {
+	return topics.NameAndroidStatus
+}
+
+func (a *androidFamily) Commands() []string {
+	return androidCommands
+}
+
+func (a *androidFamily) EncodeCommand(tree *topics.Tree, device *types.Device, spec *command.Spec, params map[string]interface{}) (string, []byte, error) {
+	if !containsString(androidCommands, spec.Name) {
+		return "", nil, fmt.Errorf("command %s is not supported by protocol %s (supported: %s)", spec.Name, a.name, strings.Join(androidCommands, ", "))
+	}
+	topic := tree.AndroidCommand(deviceType(device, a.fallbackType), spec.Name)
+	return topic, []byte(command.EncodePlain(spec.Name, params)), nil
+}
+
+func (a *androidFamily) ParseStatus(raw string) (string, string) {
+	return parseStatus(raw, a.aliases)
+}
@@ -0,0 +1,402 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/tracing"
+	"mobile-admin-mqtt-server/types"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func limit(v float64) *float64 {
+	return &v
+}
+
+// 可下发给设备的配置项
+var configSettings = []command.Param{
+	{Name: "heartbeat_interval_seconds", Type: command.ParamInt, Description: "心跳间隔（秒）", Min: limit(10), Max: limit(3600)},
+	{Name: "reconnect_delay_seconds", Type: command.ParamInt, Description: "重启4G后重新连接MQTT前等待的秒数", Min: limit(0), Max: limit(600)},
+	{Name: "restart_method", Type: command.ParamString, Description: "重启4G的方式：飞行模式或 svc data", Enum: []string{"airplane", "svc_data"}},
+	{Name: "server_url", Type: command.ParamString, Description: "MQTT服务器地址，如 tcp://mqtt.example.com:1883"},
+}
+
+// MQTT服务器地址允许的协议
+var serverURLSchemes = []string{"tcp", "ssl", "tls", "mqtt", "mqtts", "ws", "wss"}
+
+// 可下发的配置项定义
+func ConfigSettings() []command.Param {
+	return configSettings
+}
+
+func configSetting(key string) (*command.Param, bool) {
+	for i := range configSettings {
+		if configSettings[i].Name == key {
+			return &configSettings[i], true
+		}
+	}
+	return nil, false
+}
+
+// 期望配置的版本与请求中的版本不一致
+type VersionConflictError struct {
+	Current int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("config version conflict: current version is %d", e.Current)
+}
+
+// 设备配置影子
+type shadow struct {
+	desired         map[string]interface{}
+	reported        map[string]interface{}
+	version         int64
+	reportedVersion int64
+	desiredAt       *time.Time
+	reportedAt      *time.Time
+}
+
+// 配置影子文件中的一条记录
+type shadowRecord struct {
+	TenantID        string                 `json:"tenant_id"`
+	DeviceID        string                 `json:"device_id"`
+	Desired         map[string]interface{} `json:"desired"`
+	Reported        map[string]interface{} `json:"reported"`
+	Version         int64                  `json:"version"`
+	ReportedVersion int64                  `json:"reported_version"`
+	DesiredAt       *time.Time             `json:"desired_at,omitempty"`
+	ReportedAt      *time.Time             `json:"reported_at,omitempty"`
+}
+
+// 加载配置影子文件，之后的变更都会写回该文件；文件不存在时从空白开始。
+// 不持久化时重启后版本归零，而设备仍记着更高的已应用版本
+func (m *Manager) LoadConfigs(path string) error {
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+
+	m.configFile = path
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config shadow file: %v", err)
+	}
+
+	var records []*shadowRecord
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return fmt.Errorf("failed to parse config shadow file %s: %v", path, err)
+	}
+	for _, r := range records {
+		s := m.shadowFor(r.TenantID, r.DeviceID)
+		// JSON数字按配置项定义重新规范化，才能与之后上报的值比较
+		for key, value := range r.Desired {
+			if normalized, err := validateSetting(key, value); err == nil {
+				value = normalized
+			}
+			s.desired[key] = value
+		}
+		for key, value := range r.Reported {
+			if normalized, err := validateSetting(key, value); err == nil {
+				value = normalized
+			}
+			s.reported[key] = value
+		}
+		s.version = r.Version
+		s.reportedVersion = r.ReportedVersion
+		s.desiredAt = r.DesiredAt
+		s.reportedAt = r.ReportedAt
+	}
+	logger.Info("Loaded config shadow file", "path", path, "devices", len(records))
+	return nil
+}
+
+// 写回配置影子文件（先写临时文件再改名），调用方需持有 configMutex
+func (m *Manager) saveConfigs() {
+	if m.configFile == "" {
+		return
+	}
+
+	records := make([]*shadowRecord, 0)
+	for tenantID, partition := range m.configs {
+		for deviceID, s := range partition {
+			records = append(records, &shadowRecord{
+				TenantID:        tenantID,
+				DeviceID:        deviceID,
+				Desired:         s.desired,
+				Reported:        s.reported,
+				Version:         s.version,
+				ReportedVersion: s.reportedVersion,
+				DesiredAt:       s.desiredAt,
+				ReportedAt:      s.reportedAt,
+			})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].TenantID+"/"+records[i].DeviceID < records[j].TenantID+"/"+records[j].DeviceID
+	})
+
+	raw, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		logger.Error("Failed to marshal config shadows", "error", err)
+		return
+	}
+	tmp := m.configFile + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		logger.Error("Failed to write config shadow file", "path", m.configFile, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, m.configFile); err != nil {
+		logger.Error("Failed to write config shadow file", "path", m.configFile, "error", err)
+	}
+}
+
+// 设备是否声明支持配置影子，未声明的设备（如协议v1客户端）不下发 config_delta
+func supportsConfigShadow(device *types.Device) bool {
+	return containsString(device.Capabilities, types.CapabilityConfigShadow)
+}
+
+// 获取设备的配置影子，不存在时创建，调用方需持有 configMutex
+func (m *Manager) shadowFor(tenantID, deviceID string) *shadow {
+	partition, ok := m.configs[tenantID]
+	if !ok {
+		partition = make(map[string]*shadow)
+		m.configs[tenantID] = partition
+	}
+	s, ok := partition[deviceID]
+	if !ok {
+		s = &shadow{desired: map[string]interface{}{}, reported: map[string]interface{}{}}
+		partition[deviceID] = s
+	}
+	return s
+}
+
+// 生成配置文档（副本），计算 desired 与 reported 的差异
+func (s *shadow) document(deviceID string) *types.DeviceConfig {
+	doc := &types.DeviceConfig{
+		DeviceID:        deviceID,
+		Desired:         make(map[string]interface{}, len(s.desired)),
+		Reported:        make(map[string]interface{}, len(s.reported)),
+		Delta:           make(map[string]interface{}),
+		Diff:            make([]types.ConfigDiff, 0),
+		Version:         s.version,
+		ReportedVersion: s.reportedVersion,
+		DesiredAt:       s.desiredAt,
+		ReportedAt:      s.reportedAt,
+	}
+	for key, value := range s.reported {
+		doc.Reported[key] = value
+	}
+
+	keys := make([]string, 0, len(s.desired))
+	for key, value := range s.desired {
+		doc.Desired[key] = value
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		reported, ok := s.reported[key]
+		if ok && reflect.DeepEqual(s.desired[key], reported) {
+			continue
+		}
+		doc.Delta[key] = s.desired[key]
+		doc.Diff = append(doc.Diff, types.ConfigDiff{Key: key, Desired: s.desired[key], Reported: reported})
+	}
+	doc.InSync = len(doc.Delta) == 0
+	return doc
+}
+
+// 校验配置项的值，返回规范化后的值
+func validateSetting(key string, value interface{}) (interface{}, error) {
+	setting, ok := configSetting(key)
+	if !ok {
+		return nil, fmt.Errorf("unknown config key %s", key)
+	}
+	normalized, err := setting.Normalize(value)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %v", key, err)
+	}
+	if key == "server_url" {
+		u, err := url.Parse(normalized.(string))
+		if err != nil || u.Host == "" || !containsString(serverURLSchemes, u.Scheme) {
+			return nil, fmt.Errorf("config server_url: must be a URL like tcp://host:1883 (schemes: %v)", serverURLSchemes)
+		}
+	}
+	return normalized, nil
+}
+
+// 获取设备的配置文档
+func (m *Manager) GetConfig(tenantID, deviceID string) (*types.DeviceConfig, error) {
+	if _, err := m.GetDevice(tenantID, deviceID); err != nil {
+		return nil, err
+	}
+
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	return m.shadowFor(tenantID, deviceID).document(deviceID), nil
+}
+
+// 替换设备的期望配置；有变化时版本加1，并向设备下发差异
+func (m *Manager) SetDesiredConfig(ctx context.Context, tenantID, deviceID string, update *types.ConfigUpdate) (*types.DeviceConfig, error) {
+	device, err := m.GetDevice(tenantID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := make(map[string]interface{}, len(update.Desired))
+	for key, value := range update.Desired {
+		if value == nil {
+			continue
+		}
+		normalized, err := validateSetting(key, value)
+		if err != nil {
+			return nil, err
+		}
+		desired[key] = normalized
+	}
+
+	m.configMutex.Lock()
+	s := m.shadowFor(tenantID, deviceID)
+	if update.Version != nil && *update.Version != s.version {
+		m.configMutex.Unlock()
+		return nil, &VersionConflictError{Current: s.version}
+	}
+	changed := !reflect.DeepEqual(desired, s.desired)
+	if changed {
+		now := time.Now()
+		s.desired = desired
+		s.version++
+		s.desiredAt = &now
+		m.saveConfigs()
+	}
+	doc := s.document(deviceID)
+	m.configMutex.Unlock()
+
+	if changed {
+		logger.Info("Desired config updated", "tenant_id", tenantID, "device_id", deviceID, "version", doc.Version, "pending", len(doc.Delta))
+		if device.IsOnline && !doc.InSync && supportsConfigShadow(device) {
+			m.publishConfigDelta(ctx, tenantID, deviceID, doc)
+		}
+	}
+	return doc, nil
+}
+
+// 处理设备上报的配置：合并到 reported（值为null时删除该项），记录设备已应用的版本；
+// 上报的版本高于期望配置的版本时不记录该版本（服务端没有发出过这个版本）
+func (m *Manager) ReportConfig(tenantID, deviceID string, report *types.ConfigReportData) error {
+	if _, err := m.GetDevice(tenantID, deviceID); err != nil {
+		return err
+	}
+
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+
+	s := m.shadowFor(tenantID, deviceID)
+	for key, value := range report.Reported {
+		if value == nil {
+			delete(s.reported, key)
+			continue
+		}
+		// 已知配置项按定义规范化（如JSON数字转为整数），便于与期望值比较
+		if normalized, err := validateSetting(key, value); err == nil {
+			value = normalized
+		}
+		s.reported[key] = value
+	}
+	if report.Version > s.version {
+		logger.Warn("Ignoring reported config version newer than desired version", "tenant_id", tenantID, "device_id", deviceID,
+			"reported_version", report.Version, "version", s.version)
+	} else if report.Version > 0 {
+		s.reportedVersion = report.Version
+	}
+	now := time.Now()
+	s.reportedAt = &now
+	m.saveConfigs()
+
+	doc := s.document(deviceID)
+	logger.Info("Config reported", "tenant_id", tenantID, "device_id", deviceID, "reported_version", doc.ReportedVersion, "version", doc.Version, "in_sync", doc.InSync)
+	return nil
+}
+
+// 设备（重新）注册后补发未应用的配置差异
+func (m *Manager) ResendConfigDelta(ctx context.Context, tenantID, deviceID string) {
+	if device, err := m.GetDevice(tenantID, deviceID); err != nil || !supportsConfigShadow(device) {
+		return
+	}
+
+	m.configMutex.Lock()
+	s, ok := m.configs[tenantID][deviceID]
+	var doc *types.DeviceConfig
+	if ok {
+		doc = s.document(deviceID)
+	}
+	m.configMutex.Unlock()
+
+	if doc != nil && !doc.InSync {
+		m.publishConfigDelta(ctx, tenantID, deviceID, doc)
+	}
+}
+
+// 向设备下发配置差异，启用命令签名时一并签名
+func (m *Manager) publishConfigDelta(ctx context.Context, tenantID, deviceID string, doc *types.DeviceConfig) {
+	var err error
+	ctx, span := tracer.Start(ctx, "publish config_delta", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("mqtt"),
+			attribute.String("tenant_id", tenantID),
+			attribute.String("device_id", deviceID),
+			attribute.Int64("version", doc.Version),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	t, err := m.tenant(tenantID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to publish config delta", "tenant_id", tenantID, "device_id", deviceID, "error", err)
+		return
+	}
+
+	msg := types.MQTTMessage{
+		Action:    "config_delta",
+		Timestamp: time.Now().Unix(),
+		DeviceID:  deviceID,
+		Data: map[string]interface{}{
+			"version": doc.Version,
+			"delta":   doc.Delta,
+			"desired": doc.Desired,
+		},
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to marshal config delta", "tenant_id", tenantID, "device_id", deviceID, "error", err)
+		return
+	}
+
+	topic := t.Topics.ConfigDelta(deviceID)
+	span.SetAttributes(semconv.MessagingDestinationName(topic))
+	payload = tracing.InjectPayload(ctx, payload)
+	if m.signer != nil {
+		if payload, err = m.signer.SignCommand(tenantID, deviceID, topic, "config_delta", doc.Delta, payload); err != nil {
+			logger.ErrorContext(ctx, "Failed to sign config delta", "tenant_id", tenantID, "device_id", deviceID, "error", err)
+			return
+		}
+	}
+
+	token := m.client.Publish(topic, 1, false, payload)
+	if token.Wait() && token.Error() != nil {
+		err = token.Error()
+		logger.ErrorContext(ctx, "Failed to publish config delta", "tenant_id", tenantID, "device_id", deviceID, "topic", topic, "error", err)
+		return
+	}
+	logger.InfoContext(ctx, "Config delta sent", "tenant_id", tenantID, "device_id", deviceID, "topic", topic, "version", doc.Version, "delta", doc.Delta)
+}
@@ -0,0 +1,333 @@
+package device
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// 每个租户最多保留的待审批设备数
+const maxPendingDevices = 1000
+
+// 设备令牌的最短长度
+const minTokenLength = 16
+
+// 注册被拒绝或等待审批，Status 为 rejected 或 pending，用于 register_nack
+type AdmissionError struct {
+	Status string
+	Reason string
+}
+
+func (e *AdmissionError) Error() string {
+	return fmt.Sprintf("registration %s: %s", e.Status, e.Reason)
+}
+
+// 准入数据文件格式
+type enrollmentData struct {
+	Devices []*types.EnrolledDevice `json:"devices"`
+	Pending []*types.PendingDevice  `json:"pending"`
+}
+
+// 加载准入数据文件，之后的变更都会写回该文件；文件不存在时从空列表开始
+func (m *Manager) LoadEnrollment(path string) error {
+	m.enrollMutex.Lock()
+	defer m.enrollMutex.Unlock()
+
+	m.enrollmentFile = path
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read enrollment file: %v", err)
+	}
+
+	var data enrollmentData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("failed to parse enrollment file %s: %v", path, err)
+	}
+	for _, d := range data.Devices {
+		m.enrolledPartition(d.TenantID)[d.DeviceID] = d
+	}
+	for _, p := range data.Pending {
+		m.pendingPartition(p.TenantID)[p.DeviceID] = p
+	}
+	logger.Info("Loaded enrollment file", "path", path, "provisioned", len(data.Devices), "pending", len(data.Pending))
+	return nil
+}
+
+// 写回准入数据文件（先写临时文件再改名），调用方需持有 enrollMutex
+func (m *Manager) saveEnrollment() {
+	if m.enrollmentFile == "" {
+		return
+	}
+
+	data := enrollmentData{Devices: []*types.EnrolledDevice{}, Pending: []*types.PendingDevice{}}
+	for _, partition := range m.enrolled {
+		for _, d := range partition {
+			data.Devices = append(data.Devices, d)
+		}
+	}
+	for _, partition := range m.pending {
+		for _, p := range partition {
+			data.Pending = append(data.Pending, p)
+		}
+	}
+	sort.Slice(data.Devices, func(i, j int) bool {
+		return data.Devices[i].TenantID+"/"+data.Devices[i].DeviceID < data.Devices[j].TenantID+"/"+data.Devices[j].DeviceID
+	})
+	sort.Slice(data.Pending, func(i, j int) bool {
+		return data.Pending[i].TenantID+"/"+data.Pending[i].DeviceID < data.Pending[j].TenantID+"/"+data.Pending[j].DeviceID
+	})
+
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		logger.Error("Failed to marshal enrollment data", "error", err)
+		return
+	}
+	tmp := m.enrollmentFile + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		logger.Error("Failed to write enrollment file", "path", m.enrollmentFile, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, m.enrollmentFile); err != nil {
+		logger.Error("Failed to write enrollment file", "path", m.enrollmentFile, "error", err)
+	}
+}
+
+func (m *Manager) enrolledPartition(tenantID string) map[string]*types.EnrolledDevice {
+	partition, ok := m.enrolled[tenantID]
+	if !ok {
+		partition = make(map[string]*types.EnrolledDevice)
+		m.enrolled[tenantID] = partition
+	}
+	return partition
+}
+
+func (m *Manager) pendingPartition(tenantID string) map[string]*types.PendingDevice {
+	partition, ok := m.pending[tenantID]
+	if !ok {
+		partition = make(map[string]*types.PendingDevice)
+		m.pending[tenantID] = partition
+	}
+	return partition
+}
+
+// 生成随机设备令牌
+func newToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 检查设备能否注册。预配置的设备需提供匹配的令牌；verifyToken 为false表示来源无法携带令牌
+// （如状态主题自动注册），这时设有令牌的预配置设备被拒绝，须发送带令牌的 register 消息。
+// 未预配置的设备按租户的准入模式处理：open直接允许，allowlist拒绝，quarantine加入待审批列表。
+// 被拒绝或等待审批时返回 *AdmissionError
+func (m *Manager) Admit(tenantID string, candidate *types.PendingDevice, token string, verifyToken bool) error {
+	t, err := m.tenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	m.enrollMutex.Lock()
+	defer m.enrollMutex.Unlock()
+
+	now := time.Now()
+	if record, ok := m.enrolled[tenantID][candidate.DeviceID]; ok {
+		if !verifyToken && record.TokenHash != "" {
+			logger.Warn("Rejected registration", "tenant_id", tenantID, "device_id", candidate.DeviceID, "source", candidate.Source, "reason", "token required")
+			return &AdmissionError{Status: "rejected", Reason: "provisioned device must register with its token"}
+		}
+		if verifyToken && subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(record.TokenHash)) != 1 {
+			logger.Warn("Rejected registration", "tenant_id", tenantID, "device_id", candidate.DeviceID, "reason", "invalid token")
+			return &AdmissionError{Status: "rejected", Reason: "invalid token"}
+		}
+		record.LastAdmittedAt = &now
+		return nil
+	}
+
+	switch t.Enrollment {
+	case types.EnrollmentAllowlist:
+		logger.Warn("Rejected registration", "tenant_id", tenantID, "device_id", candidate.DeviceID, "reason", "device is not provisioned")
+		return &AdmissionError{Status: "rejected", Reason: "device is not provisioned"}
+
+	case types.EnrollmentQuarantine:
+		partition := m.pendingPartition(tenantID)
+		if p, ok := partition[candidate.DeviceID]; ok {
+			p.ClientID = candidate.ClientID
+			p.Protocol = candidate.Protocol
+			p.DeviceInfo = candidate.DeviceInfo
+			p.Source = candidate.Source
+			p.LastSeen = now
+			p.Attempts++
+			return &AdmissionError{Status: "pending", Reason: "device is awaiting approval"}
+		}
+		if len(partition) >= maxPendingDevices {
+			logger.Warn("Rejected registration", "tenant_id", tenantID, "device_id", candidate.DeviceID, "reason", "pending list is full")
+			return &AdmissionError{Status: "rejected", Reason: "pending list is full"}
+		}
+
+		p := *candidate
+		p.TenantID = tenantID
+		p.FirstSeen = now
+		p.LastSeen = now
+		p.Attempts = 1
+		partition[p.DeviceID] = &p
+		m.saveEnrollment()
+		logger.Info("Device quarantined, awaiting approval", "tenant_id", tenantID, "device_id", p.DeviceID)
+		return &AdmissionError{Status: "pending", Reason: "device is awaiting approval"}
+	}
+	return nil
+}
+
+// 预配置设备，token为空时生成随机令牌；返回记录和令牌明文（只在此时可见）
+func (m *Manager) ProvisionDevice(tenantID, deviceID, token string) (*types.EnrolledDevice, string, error) {
+	if deviceID == "" {
+		return nil, "", fmt.Errorf("device_id is required")
+	}
+
+	m.enrollMutex.Lock()
+	defer m.enrollMutex.Unlock()
+
+	if _, exists := m.enrolled[tenantID][deviceID]; exists {
+		return nil, "", fmt.Errorf("device %s is already provisioned", deviceID)
+	}
+	return m.provision(tenantID, deviceID, token, "api")
+}
+
+// 添加预配置记录并移出待审批列表，调用方需持有 enrollMutex
+func (m *Manager) provision(tenantID, deviceID, token, source string) (*types.EnrolledDevice, string, error) {
+	if token == "" {
+		var err error
+		if token, err = newToken(); err != nil {
+			return nil, "", err
+		}
+	} else if len(token) < minTokenLength {
+		return nil, "", fmt.Errorf("token must be at least %d characters", minTokenLength)
+	}
+
+	record := &types.EnrolledDevice{
+		DeviceID:  deviceID,
+		TenantID:  tenantID,
+		TokenHash: hashToken(token),
+		Source:    source,
+		CreatedAt: time.Now(),
+	}
+	m.enrolledPartition(tenantID)[deviceID] = record
+	delete(m.pending[tenantID], deviceID)
+	m.saveEnrollment()
+	logger.Info("Device provisioned", "tenant_id", tenantID, "device_id", deviceID, "source", source)
+	return publicRecord(record), token, nil
+}
+
+// 为预配置的设备生成新令牌，旧令牌立即失效
+func (m *Manager) RotateDeviceToken(tenantID, deviceID string) (string, error) {
+	m.enrollMutex.Lock()
+	defer m.enrollMutex.Unlock()
+
+	record, exists := m.enrolled[tenantID][deviceID]
+	if !exists {
+		return "", fmt.Errorf("device is not provisioned: %s", deviceID)
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	record.TokenHash = hashToken(token)
+	m.saveEnrollment()
+	logger.Info("Device token rotated", "tenant_id", tenantID, "device_id", deviceID)
+	return token, nil
+}
+
+// 撤销预配置，已注册的设备不受影响，但之后无法再注册
+func (m *Manager) RevokeDevice(tenantID, deviceID string) error {
+	m.enrollMutex.Lock()
+	defer m.enrollMutex.Unlock()
+
+	if _, exists := m.enrolled[tenantID][deviceID]; !exists {
+		return fmt.Errorf("device is not provisioned: %s", deviceID)
+	}
+	delete(m.enrolled[tenantID], deviceID)
+	m.saveEnrollment()
+	logger.Info("Device provisioning revoked", "tenant_id", tenantID, "device_id", deviceID)
+	return nil
+}
+
+// 获取租户预配置的设备（副本，不含令牌摘要，按设备ID排序）
+func (m *Manager) EnrolledDevices(tenantID string) []*types.EnrolledDevice {
+	m.enrollMutex.Lock()
+	defer m.enrollMutex.Unlock()
+
+	records := make([]*types.EnrolledDevice, 0, len(m.enrolled[tenantID]))
+	for _, record := range m.enrolled[tenantID] {
+		records = append(records, publicRecord(record))
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].DeviceID < records[j].DeviceID
+	})
+	return records
+}
+
+// 获取租户待审批的设备（副本，按首次出现时间排序）
+func (m *Manager) PendingDevices(tenantID string) []*types.PendingDevice {
+	m.enrollMutex.Lock()
+	defer m.enrollMutex.Unlock()
+
+	devices := make([]*types.PendingDevice, 0, len(m.pending[tenantID]))
+	for _, p := range m.pending[tenantID] {
+		c := *p
+		devices = append(devices, &c)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].FirstSeen.Before(devices[j].FirstSeen)
+	})
+	return devices
+}
+
+// 批准待审批的设备：加入预配置列表并生成令牌
+func (m *Manager) ApprovePending(tenantID, deviceID string) (*types.EnrolledDevice, string, error) {
+	m.enrollMutex.Lock()
+	defer m.enrollMutex.Unlock()
+
+	if _, exists := m.pending[tenantID][deviceID]; !exists {
+		return nil, "", fmt.Errorf("device is not pending: %s", deviceID)
+	}
+	return m.provision(tenantID, deviceID, "", "pending")
+}
+
+// 从待审批列表中移除设备（设备再次尝试注册时会重新出现）
+func (m *Manager) DismissPending(tenantID, deviceID string) error {
+	m.enrollMutex.Lock()
+	defer m.enrollMutex.Unlock()
+
+	if _, exists := m.pending[tenantID][deviceID]; !exists {
+		return fmt.Errorf("device is not pending: %s", deviceID)
+	}
+	delete(m.pending[tenantID], deviceID)
+	m.saveEnrollment()
+	logger.Info("Pending device dismissed", "tenant_id", tenantID, "device_id", deviceID)
+	return nil
+}
+
+// 复制预配置记录并去掉令牌摘要
+func publicRecord(record *types.EnrolledDevice) *types.EnrolledDevice {
+	c := *record
+	c.TokenHash = ""
+	return &c
+}
@@ -0,0 +1,237 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/types"
+)
+
+// 每个设备保留的IP变化记录条数
+const maxIPHistory = 50
+
+// 从状态说明中提取IP及来源：public_ip=x 为公网IP，ip=x 或直接为IP时视为蜂窝网络IP，
+// 如 "4g_restarted_success: ip=10.1.2.3 public_ip=1.2.3.4"，同时出现时取公网IP
+func ipFromDetail(detail string) (string, string) {
+	var ip, source string
+	for _, field := range strings.FieldsFunc(detail, func(r rune) bool { return r == ' ' || r == ',' || r == ';' }) {
+		value, fieldSource := field, types.IPSourceCellular
+		if strings.HasPrefix(field, "public_ip=") {
+			value, fieldSource = strings.TrimPrefix(field, "public_ip="), types.IPSourcePublic
+		} else {
+			value = strings.TrimPrefix(field, "ip=")
+		}
+		if net.ParseIP(value) == nil {
+			continue
+		}
+		if ip == "" || fieldSource == types.IPSourcePublic {
+			ip, source = value, fieldSource
+		}
+	}
+	return ip, source
+}
+
+// 记录设备上报的IP，调用方需持有锁；已上报过公网IP的设备不以蜂窝网络IP作为当前IP
+func (m *Manager) observeIP(device *types.Device, ip, source string) {
+	if net.ParseIP(ip) == nil {
+		return
+	}
+	if source == types.IPSourceCellular {
+		device.CellularIP = ip
+		// 重启后只上报蜂窝网络IP的客户端（如Android）按蜂窝网络IP判断是否换到了新IP
+		if device.IPSource == types.IPSourcePublic {
+			if r := device.LastRestart; r != nil && r.Status == types.RestartAwaitingIP {
+				r.IPBefore = r.CellularIPBefore
+				m.completeRestart(device, types.RestartSucceeded, ip)
+			}
+			return
+		}
+	}
+
+	if ip != device.PublicIP {
+		now := time.Now()
+		device.IPHistory = append(device.IPHistory, types.IPChange{
+			IP:       ip,
+			Previous: device.PublicIP,
+			Source:   source,
+			At:       now,
+		})
+		if len(device.IPHistory) > maxIPHistory {
+			device.IPHistory = device.IPHistory[len(device.IPHistory)-maxIPHistory:]
+		}
+		logger.Info("Device IP changed", "tenant_id", device.TenantID, "device_id", device.ID, "old_ip", device.PublicIP, "ip", ip, "source", source)
+		device.PublicIP = ip
+		device.IPSource = source
+	}
+
+	// 设备重启后恢复连接，上报的IP即为重启结果
+	if r := device.LastRestart; r != nil && r.Status == types.RestartAwaitingIP {
+		m.completeRestart(device, types.RestartSucceeded, device.PublicIP)
+	}
+}
+
+// 记录重启类命令已下发，调用方需持有锁
+func (m *Manager) beginRestart(device *types.Device, spec *command.Spec) {
+	timeout := time.Duration(spec.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+
+	now := time.Now()
+	device.LastRestart = &types.RestartResult{
+		Command:          spec.Name,
+		Status:           types.RestartPending,
+		IPBefore:         device.PublicIP,
+		RequestedAt:      now,
+		Deadline:         now.Add(timeout),
+		ResultStatuses:   spec.ResultStatuses,
+		CellularIPBefore: device.CellularIP,
+	}
+}
+
+// 命令发布失败时撤销 beginRestart 创建的重启结果，恢复之前的结果并唤醒等待的调用方
+func (m *Manager) abortRestart(tenantID, deviceID string, restart, previous *types.RestartResult) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	device, exists := m.lookup(tenantID, deviceID)
+	if !exists || device.LastRestart != restart {
+		return
+	}
+	device.LastRestart = previous
+	m.wakeRestartWaiters(tenantID, deviceID)
+}
+
+// 根据状态推进重启结果，调用方需持有锁；只有状态发生变化或上报了命令的结果状态才算数，
+// 避免命令下发后设备原有的 connected 状态被当作重启结果
+func (m *Manager) advanceRestart(device *types.Device, changed bool) {
+	r := device.LastRestart
+	if r == nil || r.Status != types.RestartPending {
+		return
+	}
+	if !changed && !containsString(r.ResultStatuses, device.NetworkStatus) {
+		return
+	}
+
+	switch device.Status {
+	case types.StateRestartFailed:
+		m.completeRestart(device, types.RestartFailed, "")
+	case types.StateConnected:
+		r.Status = types.RestartAwaitingIP
+	}
+}
+
+// 结束重启结果，成功时比较重启前的IP和ipAfter；重启前IP未知时只要上报了IP就视为新IP
+func (m *Manager) completeRestart(device *types.Device, status, ipAfter string) {
+	r := device.LastRestart
+	now := time.Now()
+	r.Status = status
+	r.CompletedAt = &now
+	if status == types.RestartSucceeded {
+		r.IPAfter = ipAfter
+		r.NewIP = r.IPAfter != "" && r.IPAfter != r.IPBefore
+	}
+	logger.Info("Restart result", "tenant_id", device.TenantID, "device_id", device.ID, "command", r.Command,
+		"status", r.Status, "ip_before", r.IPBefore, "ip_after", r.IPAfter, "new_ip", r.NewIP)
+
+	// 通知等待结果的调用方
+	m.wakeRestartWaiters(device.TenantID, device.ID)
+}
+
+// 唤醒等待设备重启结果的调用方，调用方需持有锁
+func (m *Manager) wakeRestartWaiters(tenantID, deviceID string) {
+	key := tenantID + "/" + deviceID
+	if signal, ok := m.restartSignals[key]; ok {
+		close(signal)
+		delete(m.restartSignals, key)
+	}
+}
+
+// 等待设备最近一次重启类命令结束（成功、失败或超时），返回结果副本
+func (m *Manager) WaitRestart(ctx context.Context, tenantID, deviceID string) (*types.RestartResult, error) {
+	for {
+		m.mutex.Lock()
+		device, exists := m.lookup(tenantID, deviceID)
+		if !exists {
+			m.mutex.Unlock()
+			return nil, fmt.Errorf("device not found: %s", deviceID)
+		}
+		if device.LastRestart == nil {
+			m.mutex.Unlock()
+			return nil, fmt.Errorf("no restart in progress for device %s", deviceID)
+		}
+
+		m.expireRestart(device, time.Now())
+		if device.LastRestart.CompletedAt != nil {
+			result := *device.LastRestart
+			m.mutex.Unlock()
+			return &result, nil
+		}
+
+		key := tenantID + "/" + deviceID
+		signal, ok := m.restartSignals[key]
+		if !ok {
+			signal = make(chan struct{})
+			m.restartSignals[key] = signal
+		}
+		timer := time.NewTimer(time.Until(device.LastRestart.Deadline))
+		m.mutex.Unlock()
+
+		select {
+		case <-signal:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// 将超时未完成的重启标记为超时，调用方需持有锁
+func (m *Manager) expireRestart(device *types.Device, now time.Time) {
+	r := device.LastRestart
+	if r == nil || (r.Status != types.RestartPending && r.Status != types.RestartAwaitingIP) {
+		return
+	}
+	if now.After(r.Deadline) {
+		m.completeRestart(device, types.RestartTimeout, "")
+	}
+}
+
+// 获取设备的当前IP、IP变化记录（副本）和最近一次重启结果
+func (m *Manager) IPReport(tenantID, deviceID string) (*types.DeviceIPReport, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	device, exists := m.lookup(tenantID, deviceID)
+	if !exists {
+		return nil, fmt.Errorf("device not found: %s", deviceID)
+	}
+
+	report := &types.DeviceIPReport{
+		DeviceID:  device.ID,
+		CurrentIP: device.PublicIP,
+		IPSource:  device.IPSource,
+		History:   make([]types.IPChange, len(device.IPHistory)),
+	}
+	copy(report.History, device.IPHistory)
+	if device.LastRestart != nil {
+		restart := *device.LastRestart
+		report.LastRestart = &restart
+	}
+	return report, nil
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package device
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/types"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// 已完成的token
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (doneToken) Error() error { return nil }
+
+// 测试用客户端：发布命令（非retained消息）时调用 onCommand，模拟很快回应的设备
+type testClient struct {
+	mqtt.Client
+	onCommand func()
+}
+
+func (c *testClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if !retained && c.onCommand != nil {
+		c.onCommand()
+	}
+	return doneToken{}
+}
+
+func newTestManager(t *testing.T) (*Manager, *testClient) {
+	t.Helper()
+	tenants, err := tenant.NewDefaultRegistry("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &testClient{}
+	return NewManager(client, tenants), client
+}
+
+// 设备上报的状态：原始网络状态和公网IP
+type report struct {
+	status   string
+	publicIP string
+}
+
+func TestRestartResult(t *testing.T) {
+	tests := []struct {
+		name string
+		// 下发命令前、发布命令期间（设备很快回应）和之后的上报
+		before, during, after []report
+
+		status   string
+		ipBefore string
+		ipAfter  string
+		newIP    bool
+	}{
+		{
+			name:     "public ip changed",
+			before:   []report{{status: "connected", publicIP: "203.0.113.1"}},
+			after:    []report{{status: "4g_restarted_success", publicIP: "203.0.113.2"}},
+			status:   types.RestartSucceeded,
+			ipBefore: "203.0.113.1",
+			ipAfter:  "203.0.113.2",
+			newIP:    true,
+		},
+		{
+			name:     "public ip unchanged",
+			before:   []report{{status: "connected", publicIP: "203.0.113.1"}},
+			after:    []report{{status: "4g_restarted_success", publicIP: "203.0.113.1"}},
+			status:   types.RestartSucceeded,
+			ipBefore: "203.0.113.1",
+			ipAfter:  "203.0.113.1",
+		},
+		{
+			name:    "ip unknown before restart",
+			after:   []report{{status: "4g_restarted_success: ip=10.64.0.2"}},
+			status:  types.RestartSucceeded,
+			ipAfter: "10.64.0.2",
+			newIP:   true,
+		},
+		{
+			name: "cellular only after public ip",
+			before: []report{
+				{status: "connected: ip=10.64.0.1"},
+				{status: "connected", publicIP: "203.0.113.1"},
+			},
+			after:    []report{{status: "4g_restarted_success: ip=10.64.0.2"}},
+			status:   types.RestartSucceeded,
+			ipBefore: "10.64.0.1",
+			ipAfter:  "10.64.0.2",
+			newIP:    true,
+		},
+		{
+			name: "cellular only unchanged after public ip",
+			before: []report{
+				{status: "connected: ip=10.64.0.1"},
+				{status: "connected", publicIP: "203.0.113.1"},
+			},
+			after:    []report{{status: "4g_restarted_success: ip=10.64.0.1"}},
+			status:   types.RestartSucceeded,
+			ipBefore: "10.64.0.1",
+			ipAfter:  "10.64.0.1",
+		},
+		{
+			name:     "result reported while publishing",
+			before:   []report{{status: "connected", publicIP: "203.0.113.1"}},
+			during:   []report{{status: "4g_restarted_success", publicIP: "203.0.113.2"}},
+			status:   types.RestartSucceeded,
+			ipBefore: "203.0.113.1",
+			ipAfter:  "203.0.113.2",
+			newIP:    true,
+		},
+		{
+			name:     "restart failed",
+			before:   []report{{status: "connected", publicIP: "203.0.113.1"}},
+			after:    []report{{status: "4g_restart_failed: 权限不足"}},
+			status:   types.RestartFailed,
+			ipBefore: "203.0.113.1",
+		},
+		{
+			name:     "connected without ip",
+			before:   []report{{status: "connected", publicIP: "203.0.113.1"}},
+			after:    []report{{status: "4g_restarted_success"}},
+			status:   types.RestartAwaitingIP,
+			ipBefore: "203.0.113.1",
+		},
+		{
+			name:     "stale connected status is not a result",
+			before:   []report{{status: "connected", publicIP: "203.0.113.1"}},
+			after:    []report{{status: "connected", publicIP: "203.0.113.2"}},
+			status:   types.RestartPending,
+			ipBefore: "203.0.113.1",
+		},
+	}
+
+	spec, ok := command.NewDefaultRegistry().Get("restart4g")
+	if !ok {
+		t.Fatal("restart4g is not registered")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, client := newTestManager(t)
+			if err := m.RegisterDevice(tenant.DefaultID, &types.RegisterData{DeviceID: "phone-01"}); err != nil {
+				t.Fatal(err)
+			}
+			send := func(reports []report) {
+				for _, r := range reports {
+					if err := m.UpdateDeviceStatus(tenant.DefaultID, "phone-01", &types.ClientStatus{NetworkStatus: r.status, PublicIP: r.publicIP}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			send(tt.before)
+			client.onCommand = func() { send(tt.during) }
+			if err := m.SendCommand(context.Background(), tenant.DefaultID, "phone-01", spec, nil, ""); err != nil {
+				t.Fatal(err)
+			}
+			client.onCommand = nil
+			send(tt.after)
+
+			ips, err := m.IPReport(tenant.DefaultID, "phone-01")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := ips.LastRestart
+			if r == nil {
+				t.Fatal("no restart recorded")
+			}
+			if r.Status != tt.status || r.IPBefore != tt.ipBefore || r.IPAfter != tt.ipAfter || r.NewIP != tt.newIP {
+				t.Errorf("got status=%s ip_before=%q ip_after=%q new_ip=%v, want status=%s ip_before=%q ip_after=%q new_ip=%v",
+					r.Status, r.IPBefore, r.IPAfter, r.NewIP, tt.status, tt.ipBefore, tt.ipAfter, tt.newIP)
+			}
+		})
+	}
+}
@@ -1,223 +1,752 @@
 package device
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/logging"
+	"mobile-admin-mqtt-server/signing"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/topics"
+	"mobile-admin-mqtt-server/tracing"
 	"mobile-admin-mqtt-server/types"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	logger = logging.Logger("device")
+	tracer = tracing.Tracer("device")
 )
 
 // 设备管理器
 type Manager struct {
-	devices map[string]*types.Device
+	// 按租户分区的设备表：tenantID -> deviceID -> device
+	devices map[string]map[string]*types.Device
 	mutex   sync.RWMutex
 	client  mqtt.Client
+	tenants *tenant.Registry
+	// 协议适配器：name -> adapter，device_type -> adapter
+	adapters     map[string]Adapter
+	adapterTypes map[string]Adapter
+	adapterMutex sync.RWMutex
+	// 等待重启结果的通知：tenantID/deviceID -> 重启结束时关闭的channel
+	restartSignals map[string]chan struct{}
+	// 运维人员维护的元数据：tenantID -> deviceID，手动移除设备时删除
+	metadata map[string]map[string]*metadata
+	// 注册准入：预配置的设备和待审批的设备（tenantID -> deviceID），可持久化到文件
+	enrolled       map[string]map[string]*types.EnrolledDevice
+	pending        map[string]map[string]*types.PendingDevice
+	enrollmentFile string
+	enrollMutex    sync.Mutex
+	// 命令签名密钥环，为空时不签名
+	signer *signing.Keyring
+	// 设备配置影子（tenantID -> deviceID），可持久化到文件
+	configs     map[string]map[string]*shadow
+	configFile  string
+	configMutex sync.Mutex
+	// 超过 offlineAfter 未活动视为离线，超过 removeAfter 则移除（受mutex保护）
+	offlineAfter time.Duration
+	removeAfter  time.Duration
+	// 清理协程：关闭 cleanupStop 使其退出，退出后关闭 cleanupDone
+	cleanupStop chan struct{}
+	cleanupDone chan struct{}
 }
 
 // 创建新的设备管理器
-func NewManager(client mqtt.Client) *Manager {
-	return &Manager{
-		devices: make(map[string]*types.Device),
-		client:  client,
+func NewManager(client mqtt.Client, tenants *tenant.Registry) *Manager {
+	m := &Manager{
+		devices:      make(map[string]map[string]*types.Device),
+		client:       client,
+		tenants:      tenants,
+		adapters:     make(map[string]Adapter),
+		adapterTypes: make(map[string]Adapter),
+
+		restartSignals: make(map[string]chan struct{}),
+		metadata:       make(map[string]map[string]*metadata),
+		enrolled:       make(map[string]map[string]*types.EnrolledDevice),
+		pending:        make(map[string]map[string]*types.PendingDevice),
+		configs:        make(map[string]map[string]*shadow),
+
+		offlineAfter: 5 * time.Minute,
+		removeAfter:  10 * time.Minute,
+	}
+	for _, adapter := range builtinAdapters {
+		m.RegisterAdapter(adapter)
 	}
+	return m
 }
 
-// 注册设备
-func (m *Manager) RegisterDevice(deviceID, clientID string, deviceInfo map[string]string) error {
+// 设置设备不活动超时：超过offline视为离线，超过remove则移除
+func (m *Manager) SetTimeouts(offline, remove time.Duration) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	m.offlineAfter = offline
+	m.removeAfter = remove
+}
+
+// 设置命令签名密钥环
+func (m *Manager) SetSigner(signer *signing.Keyring) {
+	m.signer = signer
+}
+
+// 命令签名密钥环，未设置时为nil
+func (m *Manager) Signer() *signing.Keyring {
+	return m.signer
+}
+
+// 注册协议适配器（同名适配器、同一设备类型会被覆盖）
+func (m *Manager) RegisterAdapter(adapter Adapter) {
+	m.adapterMutex.Lock()
+	defer m.adapterMutex.Unlock()
+	m.adapters[adapter.Name()] = adapter
+	for _, dt := range adapter.DeviceTypes() {
+		m.adapterTypes[dt] = adapter
+	}
+}
+
+// 获取协议适配器，名称为空时使用标准JSON协议
+func (m *Manager) Adapter(name string) (Adapter, error) {
+	if name == "" {
+		name = types.ProtocolJSON
+	}
+
+	m.adapterMutex.RLock()
+	defer m.adapterMutex.RUnlock()
+
+	adapter, ok := m.adapters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown device protocol: %s", name)
+	}
+	return adapter, nil
+}
+
+// 根据设备类型获取适配器
+func (m *Manager) AdapterForType(deviceType string) (Adapter, bool) {
+	m.adapterMutex.RLock()
+	defer m.adapterMutex.RUnlock()
+
+	adapter, ok := m.adapterTypes[deviceType]
+	return adapter, ok
+}
+
+// 获取所有适配器（按名称排序）
+func (m *Manager) Adapters() []Adapter {
+	m.adapterMutex.RLock()
+	defer m.adapterMutex.RUnlock()
+
+	adapters := make([]Adapter, 0, len(m.adapters))
+	for _, adapter := range m.adapters {
+		adapters = append(adapters, adapter)
+	}
+	sort.Slice(adapters, func(i, j int) bool {
+		return adapters[i].Name() < adapters[j].Name()
+	})
+	return adapters
+}
+
+// 获取适配器声明的状态主题（去重，模板名称或自定义模板）
+func (m *Manager) StatusTopics() []string {
+	seen := make(map[string]bool)
+	var statusTopics []string
+	for _, adapter := range m.Adapters() {
+		if topic := adapter.StatusTopic(); topic != "" && !seen[topic] {
+			seen[topic] = true
+			statusTopics = append(statusTopics, topic)
+		}
+	}
+	return statusTopics
+}
+
+// 匹配适配器的状态主题，返回负责的适配器和占位符的值；
+// 多个设备族共用同一主题时按主题中的 device_type 选择
+func (m *Manager) MatchStatusTopic(tree *topics.Tree, topic string) (Adapter, map[string]string, bool) {
+	for _, adapter := range m.Adapters() {
+		if adapter.StatusTopic() == "" {
+			continue
+		}
+		vars, ok := tree.Match(adapter.StatusTopic(), topic)
+		if !ok {
+			continue
+		}
+		if typed, ok := m.AdapterForType(vars[topics.VarDeviceType]); ok && typed.StatusTopic() == adapter.StatusTopic() {
+			return typed, vars, true
+		}
+		return adapter, vars, true
+	}
+	return nil, nil, false
+}
+
+// 获取租户注册表
+func (m *Manager) Tenants() *tenant.Registry {
+	return m.tenants
+}
+
+// 获取租户，不存在时返回错误
+func (m *Manager) tenant(tenantID string) (*tenant.Tenant, error) {
+	t, ok := m.tenants.Get(tenantID)
+	if !ok {
+		return nil, fmt.Errorf("tenant not found: %s", tenantID)
+	}
+	return t, nil
+}
+
+// 注册设备，protocol为设备使用的协议适配器名称（为空时使用标准JSON协议）
+func (m *Manager) RegisterDevice(tenantID string, reg *types.RegisterData) error {
+	t, err := m.tenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	adapter, err := m.Adapter(reg.Protocol)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	partition, exists := m.devices[tenantID]
+	if !exists {
+		partition = make(map[string]*types.Device)
+		m.devices[tenantID] = partition
+	}
+
+	// 新设备需检查租户配额，已存在的设备重新注册不受限制
+	if _, exists := partition[reg.DeviceID]; !exists {
+		if quota := t.DeviceQuota(); quota > 0 && len(partition) >= quota {
+			return fmt.Errorf("tenant %s reached device quota (%d)", tenantID, quota)
+		}
+	}
 
 	device := &types.Device{
-		ID:            deviceID,
-		ClientID:      clientID,
-		LastSeen:      time.Now(),
-		NetworkStatus: "unknown",
-		DeviceInfo:    deviceInfo,
-		IsOnline:      true,
+		ID:              reg.DeviceID,
+		TenantID:        tenantID,
+		ClientID:        reg.ClientID,
+		Protocol:        adapter.Name(),
+		LastSeen:        time.Now(),
+		NetworkStatus:   types.StatusUnknown,
+		DeviceInfo:      reg.DeviceInfo,
+		IsOnline:        true,
+		Status:          types.StateUnknown,
+		ProtocolVersion: NegotiateVersion(reg.ProtocolVersion),
+		Capabilities:    normalizeCapabilities(reg.Capabilities),
+	}
+	// 未声明能力时按协议限定，如旧版Android客户端只能执行 restart4g
+	if device.Capabilities == nil {
+		device.Capabilities = normalizeCapabilities(adapter.Commands())
+	}
+	device.StatusChangedAt = device.LastSeen
+
+	// 运维人员维护的元数据不能被设备上报的信息覆盖，设备被清理后重新注册时也要恢复
+	m.applyMetadata(device)
+
+	// 重新注册时保留IP跟踪状态，才能判断重启后是否换到了新IP；
+	// 客户端重连不应清空遥测数据和历史
+	if previous, exists := partition[reg.DeviceID]; exists {
+		device.Telemetry = previous.Telemetry
+		device.TelemetryHistory = previous.TelemetryHistory
+		device.PublicIP = previous.PublicIP
+		device.IPSource = previous.IPSource
+		device.IPHistory = previous.IPHistory
+		device.CellularIP = previous.CellularIP
+		device.LastRestart = previous.LastRestart
 	}
 
-	m.devices[deviceID] = device
-	log.Printf("Device registered: %s (ClientID: %s)", deviceID, clientID)
+	partition[reg.DeviceID] = device
+	m.publishState(device)
+	logger.Info("Device registered", "tenant_id", tenantID, "device_id", reg.DeviceID, "client_id", reg.ClientID, "protocol", adapter.Name(), "protocol_version", device.ProtocolVersion)
 	return nil
 }
 
+// 协商协议版本：取设备声明的版本和服务端版本中较小的一个，未声明时为1
+func NegotiateVersion(version int) int {
+	if version < 1 {
+		version = 1
+	}
+	if version > types.ProtocolVersion {
+		version = types.ProtocolVersion
+	}
+	return version
+}
+
+// 能力列表去重排序；未声明时保持nil（不限制命令）
+func normalizeCapabilities(capabilities []string) []string {
+	if capabilities == nil {
+		return nil
+	}
+	result := make([]string, 0, len(capabilities))
+	for _, c := range capabilities {
+		if c != "" && !containsString(result, c) {
+			result = append(result, c)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 检查设备是否能执行该命令（未声明能力的设备视为都能执行）
+func checkCapability(device *types.Device, name string) error {
+	if device.Capabilities == nil || containsString(device.Capabilities, name) {
+		return nil
+	}
+	return fmt.Errorf("command %s is not supported by device %s (capabilities: %s)", name, device.ID, strings.Join(device.Capabilities, ", "))
+}
+
+// 检查设备是否声明了能执行该命令，设备不存在时返回错误
+func (m *Manager) CheckCapability(tenantID, deviceID, name string) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	device, exists := m.lookup(tenantID, deviceID)
+	if !exists {
+		return fmt.Errorf("device not found: %s", deviceID)
+	}
+	return checkCapability(device, name)
+}
+
+// 查找设备，调用方需持有锁
+func (m *Manager) lookup(tenantID, deviceID string) (*types.Device, bool) {
+	device, exists := m.devices[tenantID][deviceID]
+	return device, exists
+}
+
 // 更新设备状态
-func (m *Manager) UpdateDeviceStatus(deviceID string, status *types.ClientStatus) error {
+func (m *Manager) UpdateDeviceStatus(tenantID, deviceID string, status *types.ClientStatus) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	device, exists := m.devices[deviceID]
+	device, exists := m.lookup(tenantID, deviceID)
 	if !exists {
 		return fmt.Errorf("device not found: %s", deviceID)
 	}
 
-	device.NetworkStatus = status.NetworkStatus
-	device.LastAction = status.LastAction
+	// 由设备的协议适配器将上报的状态解析为规范化状态
+	adapter, err := m.Adapter(device.Protocol)
+	if err != nil {
+		return err
+	}
 	device.LastSeen = time.Now()
-	device.IsOnline = true
+	m.markOnline(device)
+	// 只携带遥测数据的状态消息不改变网络状态
+	if status.NetworkStatus != "" {
+		device.RawStatus = status.NetworkStatus
+		device.NetworkStatus, device.StatusDetail = adapter.ParseStatus(status.NetworkStatus)
+		changed := false
+		if state, ok := statusStates[device.NetworkStatus]; ok {
+			changed = m.transition(device, state, status.NetworkStatus)
+		}
+		m.advanceRestart(device, changed)
+		if ip, source := ipFromDetail(device.StatusDetail); ip != "" {
+			m.observeIP(device, ip, source)
+		}
+	}
+	if status.PublicIP != "" {
+		m.observeIP(device, status.PublicIP, types.IPSourcePublic)
+	}
+	if status.LastAction != "" {
+		device.LastAction = status.LastAction
+	}
+	if status.Telemetry != nil {
+		if err := m.recordTelemetry(device, status.Telemetry); err != nil {
+			logger.Warn("Ignoring telemetry", "tenant_id", tenantID, "device_id", deviceID, "error", err)
+		}
+	}
+	m.publishState(device)
 
-	log.Printf("Device status updated: %s -> %s", deviceID, status.NetworkStatus)
+	logger.Debug("Device status updated", "tenant_id", tenantID, "device_id", deviceID, "status", device.Status, "raw", status.NetworkStatus)
 	return nil
 }
 
-// 设备心跳更新
-func (m *Manager) UpdateHeartbeat(deviceID string) error {
+// 处理适配器状态主题上报的纯文本状态，设备不存在时按适配器自动注册
+func (m *Manager) ReportStatus(tenantID string, adapter Adapter, vars map[string]string, raw string) error {
+	dt := vars[topics.VarDeviceType]
+	if dt == "" {
+		dt = types.DeviceTypeGeneric
+		if supported := adapter.DeviceTypes(); len(supported) > 0 {
+			dt = supported[0]
+		}
+	}
+
+	// 按设备类型上报的客户端（如Android）不带设备ID，使用设备类型生成
+	deviceID, clientID := vars[topics.VarDeviceID], vars[topics.VarDeviceID]
+	if deviceID == "" {
+		deviceID = fmt.Sprintf("%s-device", dt)
+		clientID = fmt.Sprintf("%s-client", dt)
+	}
+
+	if _, err := m.GetDevice(tenantID, deviceID); err != nil {
+		deviceInfo := map[string]string{
+			"device_type": dt,
+			"client_type": adapter.Name(),
+		}
+		// 状态主题无法携带令牌：设有令牌的预配置设备须先发送 register 消息
+		if err := m.Admit(tenantID, &types.PendingDevice{
+			DeviceID:   deviceID,
+			ClientID:   clientID,
+			Protocol:   adapter.Name(),
+			DeviceInfo: deviceInfo,
+			Source:     "status",
+		}, "", false); err != nil {
+			return err
+		}
+		reg := &types.RegisterData{
+			DeviceID:   deviceID,
+			ClientID:   clientID,
+			Protocol:   adapter.Name(),
+			DeviceInfo: deviceInfo,
+		}
+		if err := m.RegisterDevice(tenantID, reg); err != nil {
+			return fmt.Errorf("failed to auto-register device: %v", err)
+		}
+		logger.Info("Device auto-registered", "tenant_id", tenantID, "device_id", deviceID, "protocol", adapter.Name())
+	}
+
+	return m.UpdateDeviceStatus(tenantID, deviceID, &types.ClientStatus{
+		DeviceID:      deviceID,
+		NetworkStatus: raw,
+		Timestamp:     time.Now().Unix(),
+		LastAction:    "status_report",
+	})
+}
+
+// 设备心跳更新，telemetry为心跳中附带的遥测数据（可为空）
+func (m *Manager) UpdateHeartbeat(tenantID, deviceID string, telemetry *types.Telemetry) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	device, exists := m.devices[deviceID]
+	device, exists := m.lookup(tenantID, deviceID)
 	if !exists {
 		return fmt.Errorf("device not found: %s", deviceID)
 	}
 
 	device.LastSeen = time.Now()
-	device.IsOnline = true
+	m.markOnline(device)
+	if telemetry != nil {
+		if err := m.recordTelemetry(device, telemetry); err != nil {
+			logger.Warn("Ignoring telemetry", "tenant_id", tenantID, "device_id", deviceID, "error", err)
+		}
+	}
+	m.publishState(device)
 	return nil
 }
 
+// 标记设备在线，离线后重新上线时网络状态未知，调用方需持有锁
+func (m *Manager) markOnline(device *types.Device) {
+	device.IsOnline = true
+	if device.Status == types.StateOffline {
+		m.transition(device, types.StateUnknown, "")
+	}
+}
+
 // 标记设备离线
-func (m *Manager) SetDeviceOffline(deviceID string) {
+func (m *Manager) SetDeviceOffline(tenantID, deviceID string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	if device, exists := m.devices[deviceID]; exists {
-		device.IsOnline = false
-		log.Printf("Device marked as offline: %s", deviceID)
+	if device, exists := m.lookup(tenantID, deviceID); exists {
+		m.markOffline(device)
 	}
 }
 
-// 移除设备
-func (m *Manager) RemoveDevice(deviceID string) {
+// 将设备标记为离线，调用方需持有锁
+func (m *Manager) markOffline(device *types.Device) {
+	device.IsOnline = false
+	m.transition(device, types.StateOffline, "")
+	m.publishState(device)
+	logger.Info("Device marked as offline", "tenant_id", device.TenantID, "device_id", device.ID)
+}
+
+// 移除设备，并唤醒等待该设备重启结果的调用方；手动移除时同时删除元数据和配置影子
+func (m *Manager) RemoveDevice(tenantID, deviceID string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	delete(m.devices, deviceID)
-	log.Printf("Device removed: %s", deviceID)
+	if _, exists := m.lookup(tenantID, deviceID); !exists {
+		return fmt.Errorf("device not found: %s", deviceID)
+	}
+
+	m.forget(tenantID, deviceID)
+	delete(m.metadata[tenantID], deviceID)
+
+	m.configMutex.Lock()
+	delete(m.configs[tenantID], deviceID)
+	m.saveConfigs()
+	m.configMutex.Unlock()
+
+	logger.Info("Device removed", "tenant_id", tenantID, "device_id", deviceID)
+	return nil
 }
 
-// 获取所有设备
-func (m *Manager) GetAllDevices() []*types.Device {
+// 从设备表删除设备，唤醒等待其重启结果的调用方并清除状态快照，调用方需持有锁；
+// 手动移除和长时间未活动的清理共用
+func (m *Manager) forget(tenantID, deviceID string) {
+	delete(m.devices[tenantID], deviceID)
+	m.wakeRestartWaiters(tenantID, deviceID)
+	m.clearState(tenantID, deviceID)
+}
+
+// 获取租户的所有设备（副本）
+func (m *Manager) GetAllDevices(tenantID string) []*types.Device {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	devices := make([]*types.Device, 0, len(m.devices))
-	for _, device := range m.devices {
-		devices = append(devices, device)
+	partition := m.devices[tenantID]
+	devices := make([]*types.Device, 0, len(partition))
+	for _, device := range partition {
+		devices = append(devices, cloneDevice(device))
 	}
 	return devices
 }
 
-// 获取特定设备
-func (m *Manager) GetDevice(deviceID string) (*types.Device, error) {
+// 获取特定设备（副本）
+func (m *Manager) GetDevice(tenantID, deviceID string) (*types.Device, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	device, exists := m.devices[deviceID]
+	device, exists := m.lookup(tenantID, deviceID)
 	if !exists {
 		return nil, fmt.Errorf("device not found: %s", deviceID)
 	}
-	return device, nil
+	return cloneDevice(device), nil
+}
+
+// 复制设备记录，调用方需持有锁；MQTT消息处理会原地修改设备记录，
+// 返回给锁外调用方（如编码API响应）的必须是副本
+func cloneDevice(device *types.Device) *types.Device {
+	clone := *device
+	clone.DeviceInfo = cloneStringMap(device.DeviceInfo)
+	clone.InfoOverrides = cloneStringMap(device.InfoOverrides)
+	if device.Capabilities != nil {
+		clone.Capabilities = append([]string(nil), device.Capabilities...)
+	}
+	if device.IllegalTransition != nil {
+		transition := *device.IllegalTransition
+		clone.IllegalTransition = &transition
+	}
+	// 遥测数据中的指针字段只会整体替换，不会原地修改
+	if device.Telemetry != nil {
+		telemetry := *device.Telemetry
+		clone.Telemetry = &telemetry
+	}
+	clone.TelemetryHistory = append([]types.Telemetry(nil), device.TelemetryHistory...)
+	clone.IPHistory = append([]types.IPChange(nil), device.IPHistory...)
+	if device.LastRestart != nil {
+		restart := *device.LastRestart
+		clone.LastRestart = &restart
+	}
+	return &clone
 }
 
-// 发送命令到设备
-func (m *Manager) SendCommand(deviceID, command string) error {
+func cloneStringMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	clone := make(map[string]string, len(values))
+	for key, value := range values {
+		clone[key] = value
+	}
+	return clone
+}
+
+// 检查设备在线且支持该命令，返回设备
+func (m *Manager) commandTarget(tenantID, deviceID string, spec *command.Spec) (*types.Device, error) {
 	// 检查设备是否存在且在线
-	device, err := m.GetDevice(deviceID)
+	device, err := m.GetDevice(tenantID, deviceID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if !device.IsOnline {
-		return fmt.Errorf("device is offline: %s", deviceID)
+		return nil, fmt.Errorf("device is offline: %s", deviceID)
 	}
 
-	// 构建命令消息
-	msg := types.MQTTMessage{
-		Action:    "command",
-		Command:   command,
-		Timestamp: time.Now().Unix(),
-		DeviceID:  deviceID,
+	dt := deviceType(device, types.DeviceTypeGeneric)
+	if !spec.SupportsDeviceType(dt) {
+		return nil, fmt.Errorf("command %s is not supported by device type %s", spec.Name, dt)
+	}
+	if err := checkCapability(device, spec.Name); err != nil {
+		return nil, err
 	}
+	return device, nil
+}
 
-	msgBytes, err := json.Marshal(msg)
+// 发送命令到设备，由设备注册时记录的协议适配器编码；protocol非空时覆盖设备协议。
+// JSON命令载荷中附带 traceparent，设备在响应中原样返回即可关联到同一追踪
+func (m *Manager) SendCommand(ctx context.Context, tenantID, deviceID string, spec *command.Spec, params map[string]interface{}, protocol string) (err error) {
+	ctx, span := tracer.Start(ctx, "publish command", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("mqtt"),
+			attribute.String("tenant_id", tenantID),
+			attribute.String("device_id", deviceID),
+			attribute.String("command", spec.Name),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	t, err := m.tenant(tenantID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal command message: %v", err)
+		return err
 	}
 
-	// 发布命令到设备特定主题
-	topic := fmt.Sprintf("%s/%s", types.TopicCommandPrefix, deviceID)
-	token := m.client.Publish(topic, 1, false, msgBytes)
-	token.Wait()
-
-	if token.Error() != nil {
-		return fmt.Errorf("failed to publish command: %v", token.Error())
+	device, err := m.commandTarget(tenantID, deviceID, spec)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Command sent to device %s: %s", deviceID, command)
-	return nil
-}
-
-// 发送命令到Android客户端（简单字符串格式）
-func (m *Manager) SendCommandToAndroid(deviceID, command string) error {
-	// 检查设备是否存在且在线
-	device, err := m.GetDevice(deviceID)
+	if protocol == "" {
+		protocol = device.Protocol
+	}
+	adapter, err := m.Adapter(protocol)
 	if err != nil {
 		return err
 	}
 
-	if !device.IsOnline {
-		return fmt.Errorf("device is offline: %s", deviceID)
+	topic, payload, err := adapter.EncodeCommand(t.Topics, device, spec, params)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(semconv.MessagingDestinationName(topic), attribute.String("protocol", adapter.Name()))
+	payload = tracing.InjectPayload(ctx, payload)
+	if m.signer != nil {
+		if payload, err = m.signer.SignCommand(tenantID, deviceID, topic, spec.Name, spec.WithDefaults(params), payload); err != nil {
+			return err
+		}
 	}
 
-	// Android客户端期望的主题格式: device/{device_type}/restart4g
-	// 从设备信息中获取设备类型，默认使用"oppo"
-	deviceType := "oppo"
-	if device.DeviceInfo != nil {
-		if dt, ok := device.DeviceInfo["device_type"]; ok {
-			deviceType = dt
+	// 重启类命令在发布前开始跟踪结果（是否换到了新IP），否则设备很快上报的结果会被丢弃
+	var restart, previous *types.RestartResult
+	if spec.ChangesIP {
+		m.mutex.Lock()
+		if device, exists := m.lookup(tenantID, deviceID); exists {
+			previous = device.LastRestart
+			m.beginRestart(device, spec)
+			restart = device.LastRestart
 		}
+		m.mutex.Unlock()
 	}
 
-	// 构建Android客户端主题
-	topic := fmt.Sprintf("%s/%s/restart4g", types.TopicAndroidDevicePrefix, deviceType)
-	
-	// 直接发送命令字符串（不是JSON）
-	token := m.client.Publish(topic, 1, false, command)
+	token := m.client.Publish(topic, 1, false, payload)
 	token.Wait()
 
 	if token.Error() != nil {
-		return fmt.Errorf("failed to publish Android command: %v", token.Error())
+		if restart != nil {
+			m.abortRestart(tenantID, deviceID, restart, previous)
+		}
+		return fmt.Errorf("failed to publish command: %v", token.Error())
 	}
 
-	log.Printf("Android command sent to topic %s: %s", topic, command)
+	logger.InfoContext(ctx, "Command sent", "tenant_id", tenantID, "device_id", deviceID, "command", spec.Name, "protocol", adapter.Name(), "topic", topic)
 	return nil
 }
 
-// 启动设备清理协程
+// 启动设备清理协程，StopCleanup 或 Close 时停止
 func (m *Manager) StartCleanup() {
 	ticker := time.NewTicker(60 * time.Second) // 每分钟检查一次
+	m.cleanupStop = make(chan struct{})
+	m.cleanupDone = make(chan struct{})
 	go func() {
-		for range ticker.C {
+		defer close(m.cleanupDone)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-m.cleanupStop:
+				return
+			}
 			m.mutex.Lock()
 			now := time.Now()
-			for deviceID, device := range m.devices {
-				// 5分钟未活动视为离线
-				if now.Sub(device.LastSeen) > 5*time.Minute {
-					device.IsOnline = false
-					log.Printf("Device marked as offline due to inactivity: %s", deviceID)
-				}
-				// 10分钟未活动则移除设备
-				if now.Sub(device.LastSeen) > 10*time.Minute {
-					delete(m.devices, deviceID)
-					log.Printf("Device removed due to long inactivity: %s", deviceID)
+			for tenantID, partition := range m.devices {
+				for deviceID, device := range partition {
+					m.expireRestart(device, now)
+					// 超时未活动视为离线
+					if now.Sub(device.LastSeen) > m.offlineAfter {
+						if device.IsOnline {
+							device.IsOnline = false
+							m.transition(device, types.StateOffline, "")
+							m.publishState(device)
+							logger.Info("Device marked as offline due to inactivity", "tenant_id", tenantID, "device_id", deviceID)
+						}
+					}
+					// 长时间未活动则移除设备
+					if now.Sub(device.LastSeen) > m.removeAfter {
+						m.forget(tenantID, deviceID)
+						logger.Info("Device removed due to long inactivity", "tenant_id", tenantID, "device_id", deviceID)
+					}
 				}
 			}
 			m.mutex.Unlock()
 		}
 	}()
-}
\ No newline at end of file
+}
+
+// 停止清理协程，等待正在进行的清理结束
+func (m *Manager) StopCleanup() {
+	if m.cleanupStop != nil {
+		close(m.cleanupStop)
+		<-m.cleanupDone
+		m.cleanupStop = nil
+	}
+}
+
+// 关闭设备管理器：停止清理协程，并写回只在内存中更新的准入数据（待审批设备的注册次数等）
+func (m *Manager) Close() {
+	m.StopCleanup()
+
+	m.enrollMutex.Lock()
+	m.saveEnrollment()
+	m.enrollMutex.Unlock()
+}
+
+// 发布设备状态快照（retained），调用方需持有锁以保证发布顺序
+func (m *Manager) publishState(device *types.Device) {
+	t, ok := m.tenants.Get(device.TenantID)
+	if !ok {
+		return
+	}
+
+	state := types.DeviceState{
+		DeviceID:      device.ID,
+		Online:        device.IsOnline,
+		NetworkStatus: device.NetworkStatus,
+		StatusDetail:  device.StatusDetail,
+		Status:        device.Status,
+		LastSeen:      device.LastSeen.Unix(),
+		DeviceInfo:    device.DeviceInfo,
+		Name:          device.Name,
+		Location:      device.Location,
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logger.Error("Failed to marshal device state", "tenant_id", device.TenantID, "device_id", device.ID, "error", err)
+		return
+	}
+
+	m.publishRetained(t.Topics.DeviceState(device.ID), payload)
+}
+
+// 清除设备状态快照（发布空的retained消息）
+func (m *Manager) clearState(tenantID, deviceID string) {
+	if t, ok := m.tenants.Get(tenantID); ok {
+		m.publishRetained(t.Topics.DeviceState(deviceID), []byte{})
+	}
+}
+
+// 发布retained消息，不在锁内等待发布结果
+func (m *Manager) publishRetained(topic string, payload []byte) {
+	token := m.client.Publish(topic, 1, true, payload)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			logger.Error("Failed to publish retained message", "topic", topic, "error", token.Error())
+		}
+	}()
+}
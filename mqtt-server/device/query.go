@@ -0,0 +1,207 @@
+package device
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// 设备列表分页大小
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// 设备列表支持的排序字段
+var sortKeys = map[string]func(d *types.Device) string{
+	"id":          func(d *types.Device) string { return d.ID },
+	"name":        func(d *types.Device) string { return d.Name },
+	"last_seen":   func(d *types.Device) string { return fmt.Sprintf("%020d", d.LastSeen.UnixNano()) },
+	"status":      func(d *types.Device) string { return string(d.Status) },
+	"device_type": func(d *types.Device) string { return deviceType(d, "") },
+	"public_ip":   func(d *types.Device) string { return d.PublicIP },
+}
+
+// 设备列表查询条件，零值表示不过滤
+type Query struct {
+	Online         *bool
+	DeviceTypes    []string
+	Statuses       []types.NetworkState
+	Info           map[string]string
+	LastSeenAfter  time.Time
+	LastSeenBefore time.Time
+	// 在设备ID、客户端ID、名称、位置、备注、IP、运营商和设备信息中搜索（不区分大小写）
+	Search string
+
+	// 排序字段，前缀 "-" 表示降序，默认按设备ID升序
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// 校验排序字段和分页参数并补全默认值
+func (q *Query) normalize() error {
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	if _, ok := sortKeys[strings.TrimPrefix(q.Sort, "-")]; !ok {
+		fields := make([]string, 0, len(sortKeys))
+		for field := range sortKeys {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		return fmt.Errorf("unknown sort field %q, expected one of %v", q.Sort, fields)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	if q.Limit > MaxQueryLimit {
+		return fmt.Errorf("limit must be <= %d", MaxQueryLimit)
+	}
+	return nil
+}
+
+// 判断设备是否满足过滤条件
+func (q *Query) match(d *types.Device) bool {
+	if q.Online != nil && d.IsOnline != *q.Online {
+		return false
+	}
+	if len(q.DeviceTypes) > 0 && !containsString(q.DeviceTypes, deviceType(d, "")) {
+		return false
+	}
+	if len(q.Statuses) > 0 {
+		matched := false
+		for _, state := range q.Statuses {
+			if d.Status == state {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for key, value := range q.Info {
+		if d.DeviceInfo[key] != value {
+			return false
+		}
+	}
+	if !q.LastSeenAfter.IsZero() && d.LastSeen.Before(q.LastSeenAfter) {
+		return false
+	}
+	if !q.LastSeenBefore.IsZero() && d.LastSeen.After(q.LastSeenBefore) {
+		return false
+	}
+	if q.Search != "" && !searchDevice(d, strings.ToLower(q.Search)) {
+		return false
+	}
+	return true
+}
+
+// 在设备的标识、运维元数据、IP、运营商和设备信息中查找关键字
+func searchDevice(d *types.Device, term string) bool {
+	fields := []string{d.ID, d.ClientID, d.Name, d.Location, d.Notes, d.PublicIP}
+	if d.Telemetry != nil {
+		fields = append(fields, d.Telemetry.Carrier)
+	}
+	for _, value := range d.DeviceInfo {
+		fields = append(fields, value)
+	}
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// 游标：排序字段、最后一条记录的排序值和设备ID
+func encodeCursor(sortField, key, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortField + "\n" + key + "\n" + id))
+}
+
+func decodeCursor(cursor, sortField string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), "\n", 3)
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("invalid cursor")
+	}
+	if parts[0] != sortField {
+		return "", "", fmt.Errorf("cursor was issued for sort %q, not %q", parts[0], sortField)
+	}
+	return parts[1], parts[2], nil
+}
+
+// 按条件查询租户的设备，返回一页结果（副本）、符合条件的总数和下一页游标
+func (m *Manager) QueryDevices(tenantID string, q Query) (*types.DevicePage, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	desc := strings.HasPrefix(q.Sort, "-")
+	key := sortKeys[strings.TrimPrefix(q.Sort, "-")]
+
+	var afterKey, afterID string
+	if q.Cursor != "" {
+		var err error
+		if afterKey, afterID, err = decodeCursor(q.Cursor, q.Sort); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	type entry struct {
+		key    string
+		device *types.Device
+	}
+	page := &types.DevicePage{Devices: make([]*types.Device, 0)}
+	var matched []entry
+	for _, d := range m.devices[tenantID] {
+		if !q.match(d) {
+			continue
+		}
+		page.Total++
+		if d.IsOnline {
+			page.Online++
+		}
+		matched = append(matched, entry{key: key(d), device: d})
+	}
+
+	// 按排序值排序，排序值相同时按设备ID，保证游标稳定
+	less := func(aKey, aID, bKey, bID string) bool {
+		if aKey != bKey {
+			return (aKey < bKey) != desc
+		}
+		return aID < bID
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return less(matched[i].key, matched[i].device.ID, matched[j].key, matched[j].device.ID)
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		start = sort.Search(len(matched), func(i int) bool {
+			return less(afterKey, afterID, matched[i].key, matched[i].device.ID)
+		})
+	}
+	end := start + q.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	for _, e := range matched[start:end] {
+		page.Devices = append(page.Devices, cloneDevice(e.device))
+	}
+	if end < len(matched) {
+		last := matched[end-1]
+		page.NextCursor = encodeCursor(q.Sort, last.key, last.device.ID)
+	}
+	return page, nil
+}
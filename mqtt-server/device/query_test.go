@@ -0,0 +1,154 @@
+package device
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/types"
+)
+
+// 按游标逐页查询，返回各页的设备ID
+func queryPages(t *testing.T, m *Manager, q Query) ([][]string, int) {
+	t.Helper()
+	var pages [][]string
+	total := 0
+	for {
+		page, err := m.QueryDevices(tenant.DefaultID, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0, len(page.Devices))
+		for _, d := range page.Devices {
+			ids = append(ids, d.ID)
+		}
+		pages = append(pages, ids)
+		total = page.Total
+		if page.NextCursor == "" {
+			return pages, total
+		}
+		if len(pages) > 10 {
+			t.Fatalf("pagination does not terminate: %v", pages)
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func TestQueryDevicesPagination(t *testing.T) {
+	m, _ := newTestManager(t)
+	// 名称有重复，按名称排序时以设备ID区分先后
+	names := map[string]string{"d1": "beta", "d2": "alpha", "d3": "beta", "d4": "gamma", "d5": "alpha"}
+	for _, id := range []string{"d1", "d2", "d3", "d4", "d5"} {
+		if err := m.RegisterDevice(tenant.DefaultID, &types.RegisterData{DeviceID: id}); err != nil {
+			t.Fatal(err)
+		}
+		name := names[id]
+		if _, err := m.UpdateDevice(tenant.DefaultID, id, &types.DeviceUpdate{Name: &name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.UpdateDevice(tenant.DefaultID, "d4", &types.DeviceUpdate{IsOnline: new(bool)}); err != nil {
+		t.Fatal(err)
+	}
+	online := true
+
+	tests := []struct {
+		name  string
+		query Query
+		pages [][]string
+		total int
+	}{
+		{
+			name:  "default sort single page",
+			query: Query{},
+			pages: [][]string{{"d1", "d2", "d3", "d4", "d5"}},
+			total: 5,
+		},
+		{
+			name:  "id ascending",
+			query: Query{Limit: 2},
+			pages: [][]string{{"d1", "d2"}, {"d3", "d4"}, {"d5"}},
+			total: 5,
+		},
+		{
+			name:  "exact multiple of limit",
+			query: Query{Limit: 5},
+			pages: [][]string{{"d1", "d2", "d3", "d4", "d5"}},
+			total: 5,
+		},
+		{
+			name:  "id descending",
+			query: Query{Sort: "-id", Limit: 2},
+			pages: [][]string{{"d5", "d4"}, {"d3", "d2"}, {"d1"}},
+			total: 5,
+		},
+		{
+			name:  "duplicate sort keys",
+			query: Query{Sort: "name", Limit: 2},
+			pages: [][]string{{"d2", "d5"}, {"d1", "d3"}, {"d4"}},
+			total: 5,
+		},
+		{
+			name:  "duplicate sort keys descending",
+			query: Query{Sort: "-name", Limit: 2},
+			pages: [][]string{{"d4", "d1"}, {"d3", "d2"}, {"d5"}},
+			total: 5,
+		},
+		{
+			name:  "filtered",
+			query: Query{Online: &online, Limit: 3},
+			pages: [][]string{{"d1", "d2", "d3"}, {"d5"}},
+			total: 4,
+		},
+		{
+			name:  "no match",
+			query: Query{Search: "nothing"},
+			pages: [][]string{{}},
+			total: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, total := queryPages(t, m, tt.query)
+			if !reflect.DeepEqual(pages, tt.pages) {
+				t.Errorf("pages %v, want %v", pages, tt.pages)
+			}
+			if total != tt.total {
+				t.Errorf("total %d, want %d", total, tt.total)
+			}
+		})
+	}
+}
+
+func TestQueryDevicesInvalid(t *testing.T) {
+	m, _ := newTestManager(t)
+	for _, id := range []string{"d1", "d2", "d3"} {
+		if err := m.RegisterDevice(tenant.DefaultID, &types.RegisterData{DeviceID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page, err := m.QueryDevices(tenant.DefaultID, Query{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		err   string
+	}{
+		{name: "unknown sort field", query: Query{Sort: "color"}, err: "unknown sort field"},
+		{name: "limit too large", query: Query{Limit: MaxQueryLimit + 1}, err: "limit must be"},
+		{name: "malformed cursor", query: Query{Cursor: "!!"}, err: "invalid cursor"},
+		{name: "cursor from another sort", query: Query{Sort: "-id", Cursor: page.NextCursor}, err: "was issued for sort"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.QueryDevices(tenant.DefaultID, tt.query)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
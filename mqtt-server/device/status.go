@@ -0,0 +1,103 @@
+package device
+
+import (
+	"fmt"
+	"time"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// 所有网络状态（用于校验API过滤参数）
+var networkStates = []types.NetworkState{
+	types.StateUnknown,
+	types.StateConnected,
+	types.StateConnecting,
+	types.StateDisconnected,
+	types.StateRestarting,
+	types.StateRestartFailed,
+	types.StateOffline,
+}
+
+// 允许的状态变化；同一状态、变为unknown、从unknown和offline变为任意状态总是允许
+var allowedTransitions = map[types.NetworkState][]types.NetworkState{
+	types.StateConnected: {
+		types.StateConnecting, types.StateDisconnected, types.StateRestarting, types.StateOffline,
+	},
+	types.StateConnecting: {
+		types.StateConnected, types.StateDisconnected, types.StateRestarting, types.StateRestartFailed, types.StateOffline,
+	},
+	types.StateDisconnected: {
+		types.StateConnected, types.StateConnecting, types.StateRestarting, types.StateOffline,
+	},
+	types.StateRestarting: {
+		types.StateConnected, types.StateConnecting, types.StateDisconnected, types.StateRestartFailed, types.StateOffline,
+	},
+	types.StateRestartFailed: {
+		types.StateConnected, types.StateConnecting, types.StateDisconnected, types.StateRestarting, types.StateOffline,
+	},
+}
+
+// 规范化状态 -> 网络状态；不在表中的状态（如 command_received、pong）
+// 是事件而不是状态，不改变状态机
+var statusStates = map[string]types.NetworkState{
+	types.StatusUnknown:        types.StateUnknown,
+	types.StatusConnected:      types.StateConnected,
+	types.StatusConnecting:     types.StateConnecting,
+	types.StatusDisconnected:   types.StateDisconnected,
+	types.StatusRestarting:     types.StateRestarting,
+	types.StatusRestartSuccess: types.StateConnected,
+	types.StatusRestartFailed:  types.StateRestartFailed,
+	// 内置命令的结果状态
+	"data_enabled":           types.StateConnected,
+	"data_disabled":          types.StateDisconnected,
+	"airplane_toggled":       types.StateConnected,
+	"airplane_toggle_failed": types.StateRestartFailed,
+	"rebooting":              types.StateRestarting,
+	"reboot_failed":          types.StateRestartFailed,
+}
+
+// 解析网络状态名称
+func ParseState(name string) (types.NetworkState, error) {
+	for _, state := range networkStates {
+		if string(state) == name {
+			return state, nil
+		}
+	}
+	return "", fmt.Errorf("unknown status %q, expected one of %v", name, networkStates)
+}
+
+// 检查状态变化是否合法
+func canTransition(from, to types.NetworkState) bool {
+	if from == to || to == types.StateUnknown || from == types.StateUnknown || from == types.StateOffline {
+		return true
+	}
+	for _, allowed := range allowedTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// 切换设备状态，调用方需持有锁；非法变化仍然生效（以设备实际上报为准），但会被记录。
+// 返回状态是否发生了变化
+func (m *Manager) transition(device *types.Device, to types.NetworkState, raw string) bool {
+	if device.Status == to {
+		return false
+	}
+
+	now := time.Now()
+	if !canTransition(device.Status, to) {
+		device.IllegalTransition = &types.StatusTransition{
+			From:      device.Status,
+			To:        to,
+			RawStatus: raw,
+			At:        now,
+		}
+		logger.Warn("Illegal status transition", "tenant_id", device.TenantID, "device_id", device.ID, "from", device.Status, "to", to, "raw", raw)
+	}
+
+	device.Status = to
+	device.StatusChangedAt = now
+	return true
+}
@@ -0,0 +1,136 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// 每个设备保留的遥测历史条数
+const maxTelemetryHistory = 120
+
+// 校验遥测数据并规范化网络类型
+func normalizeTelemetry(t *types.Telemetry) error {
+	if t.Battery != nil && (*t.Battery < 0 || *t.Battery > 100) {
+		return fmt.Errorf("battery out of range: %d", *t.Battery)
+	}
+	if t.DataRxBytes != nil && *t.DataRxBytes < 0 {
+		return fmt.Errorf("data_rx_bytes must not be negative")
+	}
+	if t.DataTxBytes != nil && *t.DataTxBytes < 0 {
+		return fmt.Errorf("data_tx_bytes must not be negative")
+	}
+	if t.Timestamp == 0 {
+		t.Timestamp = time.Now().Unix()
+	}
+	t.NetworkType = strings.ToUpper(strings.TrimSpace(t.NetworkType))
+	return nil
+}
+
+// 将上报的字段合并到最新遥测数据中，未上报的字段保留上一次的值
+func mergeTelemetry(latest *types.Telemetry, sample types.Telemetry) *types.Telemetry {
+	if latest == nil {
+		return &sample
+	}
+
+	merged := *latest
+	merged.Timestamp = sample.Timestamp
+	if sample.RSRP != nil {
+		merged.RSRP = sample.RSRP
+	}
+	if sample.RSRQ != nil {
+		merged.RSRQ = sample.RSRQ
+	}
+	if sample.SINR != nil {
+		merged.SINR = sample.SINR
+	}
+	if sample.Carrier != "" {
+		merged.Carrier = sample.Carrier
+	}
+	if sample.CellID != "" {
+		merged.CellID = sample.CellID
+	}
+	if sample.NetworkType != "" {
+		merged.NetworkType = sample.NetworkType
+	}
+	if sample.IPAddress != "" {
+		merged.IPAddress = sample.IPAddress
+	}
+	if sample.PublicIP != "" {
+		merged.PublicIP = sample.PublicIP
+	}
+	if sample.Battery != nil {
+		merged.Battery = sample.Battery
+	}
+	if sample.Charging != nil {
+		merged.Charging = sample.Charging
+	}
+	if sample.DataRxBytes != nil {
+		merged.DataRxBytes = sample.DataRxBytes
+	}
+	if sample.DataTxBytes != nil {
+		merged.DataTxBytes = sample.DataTxBytes
+	}
+	return &merged
+}
+
+// 记录遥测数据，调用方需持有锁
+func (m *Manager) recordTelemetry(device *types.Device, sample *types.Telemetry) error {
+	if err := normalizeTelemetry(sample); err != nil {
+		return fmt.Errorf("invalid telemetry from device %s: %v", device.ID, err)
+	}
+
+	device.Telemetry = mergeTelemetry(device.Telemetry, *sample)
+	if sample.PublicIP != "" {
+		m.observeIP(device, sample.PublicIP, types.IPSourcePublic)
+	} else if sample.IPAddress != "" {
+		m.observeIP(device, sample.IPAddress, types.IPSourceCellular)
+	}
+	device.TelemetryHistory = append(device.TelemetryHistory, *sample)
+	if len(device.TelemetryHistory) > maxTelemetryHistory {
+		device.TelemetryHistory = device.TelemetryHistory[len(device.TelemetryHistory)-maxTelemetryHistory:]
+	}
+	return nil
+}
+
+// 获取设备的遥测历史（副本，按时间先后排列）
+func (m *Manager) TelemetryHistory(tenantID, deviceID string) ([]types.Telemetry, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	device, exists := m.lookup(tenantID, deviceID)
+	if !exists {
+		return nil, fmt.Errorf("device not found: %s", deviceID)
+	}
+
+	history := make([]types.Telemetry, len(device.TelemetryHistory))
+	copy(history, device.TelemetryHistory)
+	return history, nil
+}
+
+// 在锁内生成租户设备的指标快照（按设备ID排序）
+func (m *Manager) MetricsSnapshot(tenantID string) []types.DeviceMetrics {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	snapshot := make([]types.DeviceMetrics, 0, len(m.devices[tenantID]))
+	for _, device := range m.devices[tenantID] {
+		metrics := types.DeviceMetrics{
+			DeviceID: device.ID,
+			IsOnline: device.IsOnline,
+			Status:   device.Status,
+		}
+		if device.Telemetry != nil {
+			telemetry := *device.Telemetry
+			metrics.Telemetry = &telemetry
+		}
+		snapshot = append(snapshot, metrics)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].DeviceID < snapshot[j].DeviceID
+	})
+	return snapshot
+}
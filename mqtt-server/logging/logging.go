@@ -0,0 +1,293 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 比 debug 更详细的级别，用于逐条记录MQTT消息等
+const LevelTrace = slog.Level(-8)
+
+// 日志格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// 子系统：每个子系统可单独设置日志级别
+var Subsystems = []string{
+	"server",    // 启动、配置和关闭
+	"mqtt",      // MQTT连接和消息处理
+	"paho",      // MQTT客户端库
+	"device",    // 设备注册、状态和命令
+	"http",      // HTTP请求
+	"logs",      // 设备日志收集
+	"artifacts", // 应用制品库
+	"rotation",  // 换IP任务
+	"signing",   // 命令签名密钥
+	"schema",    // 入站消息校验
+}
+
+// 日志配置
+type Options struct {
+	Format string
+	// 默认级别，Levels 中未列出的子系统使用该级别
+	Level  string
+	Levels map[string]string
+	// 日志同时写入的文件，为空时只输出到标准错误
+	File string
+	// 文件超过该大小（MB）时轮转，0表示不轮转
+	MaxSizeMB int
+	// 保留的轮转文件数（file.1 ... file.N）
+	MaxBackups int
+}
+
+// 解析日志级别：trace、debug、info、warn、error
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "trace":
+		return LevelTrace, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (use trace, debug, info, warn or error)", s)
+}
+
+// 校验日志配置
+func (o Options) Validate() error {
+	if o.Format != FormatText && o.Format != FormatJSON {
+		return fmt.Errorf("unknown log format %q (use %s or %s)", o.Format, FormatText, FormatJSON)
+	}
+	if _, err := ParseLevel(o.Level); err != nil {
+		return err
+	}
+	for name, level := range o.Levels {
+		if !knownSubsystem(name) {
+			return fmt.Errorf("unknown log subsystem %q (known: %s)", name, strings.Join(Subsystems, ", "))
+		}
+		if _, err := ParseLevel(level); err != nil {
+			return fmt.Errorf("subsystem %s: %v", name, err)
+		}
+	}
+	if o.MaxSizeMB < 0 || o.MaxBackups < 0 {
+		return fmt.Errorf("log rotation limits must not be negative")
+	}
+	return nil
+}
+
+func knownSubsystem(name string) bool {
+	for _, s := range Subsystems {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 各子系统的生效级别
+type levelSet struct {
+	def    slog.Level
+	levels map[string]slog.Level
+}
+
+func (l *levelSet) get(subsystem string) slog.Level {
+	if level, ok := l.levels[subsystem]; ok {
+		return level
+	}
+	return l.def
+}
+
+// 当前的日志输出（格式化handler和日志文件）
+type output struct {
+	handler slog.Handler
+	file    *rotatingFile
+	opts    Options
+}
+
+var (
+	levels  atomic.Pointer[levelSet]
+	current atomic.Pointer[output]
+	// 串行化 Configure
+	configMutex sync.Mutex
+)
+
+func init() {
+	levels.Store(&levelSet{def: slog.LevelInfo})
+	current.Store(&output{handler: newHandler(FormatText, os.Stderr), opts: Options{Format: FormatText}})
+}
+
+// 应用日志配置（启动时和重新加载配置时调用）。格式和文件未变化时只重新打开日志文件，以配合 logrotate
+func Configure(opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	set := &levelSet{levels: make(map[string]slog.Level)}
+	set.def, _ = ParseLevel(opts.Level)
+	for name, level := range opts.Levels {
+		set.levels[name], _ = ParseLevel(level)
+	}
+
+	old := current.Load()
+	if old.file != nil && old.opts.Format == opts.Format && old.opts.File == opts.File {
+		old.file.setLimits(int64(opts.MaxSizeMB)*1024*1024, opts.MaxBackups)
+		if err := old.file.reopen(); err != nil {
+			return err
+		}
+		old.opts = opts
+		levels.Store(set)
+		return nil
+	}
+
+	var w io.Writer = os.Stderr
+	var file *rotatingFile
+	if opts.File != "" {
+		var err error
+		if file, err = openRotatingFile(opts.File, int64(opts.MaxSizeMB)*1024*1024, opts.MaxBackups); err != nil {
+			return err
+		}
+		w = io.MultiWriter(os.Stderr, file)
+	}
+
+	current.Store(&output{handler: newHandler(opts.Format, w), file: file, opts: opts})
+	levels.Store(set)
+	if old.file != nil {
+		old.file.Close()
+	}
+	return nil
+}
+
+// 关闭日志文件（退出前调用），之后的日志只输出到标准错误
+func Close() error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	old := current.Load()
+	if old.file == nil {
+		return nil
+	}
+	opts := old.opts
+	opts.File = ""
+	current.Store(&output{handler: newHandler(opts.Format, os.Stderr), opts: opts})
+	return old.file.Close()
+}
+
+// 创建格式化handler，级别由子系统handler过滤
+func newHandler(format string, w io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{
+		Level: LevelTrace,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				if level, ok := a.Value.Any().(slog.Level); ok && level <= LevelTrace {
+					a.Value = slog.StringValue("TRACE")
+				}
+			}
+			return a
+		},
+	}
+	if format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// 获取子系统的日志器；可在 Configure 之前创建，输出和级别随配置变化
+func Logger(subsystem string) *slog.Logger {
+	return slog.New(&handler{subsystem: subsystem})
+}
+
+// 子系统handler：按子系统级别过滤，再交给当前的格式化handler
+type handler struct {
+	subsystem string
+	// WithAttrs/WithGroup 的调用，切换输出后按顺序重放
+	ops   []func(slog.Handler) slog.Handler
+	cache atomic.Pointer[resolved]
+}
+
+type resolved struct {
+	out     *output
+	handler slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= levels.Load().get(h.subsystem)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	// 带追踪上下文记录的日志附加 trace_id，便于与追踪系统中的span关联
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.resolve().Handle(ctx, r)
+}
+
+func (h *handler) resolve() slog.Handler {
+	out := current.Load()
+	if c := h.cache.Load(); c != nil && c.out == out {
+		return c.handler
+	}
+	next := out.handler.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+	for _, op := range h.ops {
+		next = op(next)
+	}
+	h.cache.Store(&resolved{out: out, handler: next})
+	return next
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{subsystem: h.subsystem, ops: append(ops, op)}
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+// 适配 Printf/Println 风格的日志接口（如 paho.mqtt.golang 的 Logger）
+type Printer struct {
+	Logger *slog.Logger
+	Level  slog.Level
+	// 包含其中任一子串的消息属于常规情况，按 debug 输出
+	Routine []string
+}
+
+func (p Printer) Println(v ...interface{}) {
+	p.log(strings.TrimSpace(fmt.Sprintln(v...)))
+}
+
+func (p Printer) Printf(format string, v ...interface{}) {
+	p.log(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (p Printer) log(msg string) {
+	level := p.Level
+	for _, routine := range p.Routine {
+		if strings.Contains(msg, routine) {
+			level = slog.LevelDebug
+			break
+		}
+	}
+	p.Logger.Log(context.Background(), level, msg)
+}
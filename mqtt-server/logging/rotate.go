@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// 按大小轮转的日志文件：超过上限时 file -> file.1 -> file.2 ...，只保留 maxBackups 个
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	// 上次打开失败（如磁盘已满、目录权限变化）时重试
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// 轮转：依次重命名旧文件，超出保留数的删除，然后打开新文件。
+// 改名失败时继续写入原文件，只在 stderr 上报告，避免文件日志就此中断
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	var err error
+	if f.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		err = os.Rename(f.path, f.path+".1")
+	} else {
+		err = os.Remove(f.path)
+	}
+	if err == nil || os.IsNotExist(err) {
+		return f.open()
+	}
+
+	fmt.Fprintf(os.Stderr, "failed to rotate log file %s, continuing without rotation: %v\n", f.path, err)
+	if err := f.open(); err != nil {
+		return err
+	}
+	// 再写满 maxSize 后才重试，避免每次写入都报错
+	f.size = 0
+	return nil
+}
+
+func (f *rotatingFile) setLimits(maxSize int64, maxBackups int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.maxSize = maxSize
+	f.maxBackups = maxBackups
+}
+
+// 重新打开文件（外部工具如 logrotate 移走文件后调用）
+func (f *rotatingFile) reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	f.file.Sync()
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,466 @@
+package logs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/device"
+	"mobile-admin-mqtt-server/logging"
+	"mobile-admin-mqtt-server/schema"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/types"
+
+	"github.com/google/uuid"
+)
+
+var logger = logging.Logger("logs")
+
+// 请求设备上传日志的命令名称
+const CommandName = "collect_logs"
+
+const (
+	// 上传中的日志包超过该时间没有收到新分片即失败
+	uploadTimeout = 10 * time.Minute
+	// 每个设备保留的已结束日志包数
+	maxBundlesPerDevice = 20
+)
+
+// 日志收集器：下发 collect_logs 命令，接收设备分片上传的日志，合并后保存到磁盘
+type Collector struct {
+	devices *device.Manager
+	// 保存目录：<dir>/<tenant>/<bundle>.json（元数据）、.log（日志）、.parts/（上传中的分片）
+	dir       string
+	maxSize   int64
+	retention time.Duration
+
+	uploads map[string]*upload
+	mutex   sync.Mutex
+
+	// 清理协程：关闭 cleanupStop 使其退出，退出后关闭 cleanupDone
+	cleanupStop chan struct{}
+	cleanupDone chan struct{}
+}
+
+// 日志包及其已收到的分片序号
+type upload struct {
+	bundle   *types.LogBundle
+	received map[int]bool
+}
+
+// 创建日志收集器并加载目录中已保存的日志包；maxSize 为单个日志包的最大字节数
+func NewCollector(devices *device.Manager, dir string, maxSize int64, retention time.Duration) (*Collector, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("log bundle size limit must be positive")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+
+	c := &Collector{
+		devices:   devices,
+		dir:       dir,
+		maxSize:   maxSize,
+		retention: retention,
+		uploads:   make(map[string]*upload),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 加载已保存的日志包，重启前未完成的上传标记为失败
+func (c *Collector) load() error {
+	paths, err := filepath.Glob(filepath.Join(c.dir, "*", "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list log bundles: %v", err)
+	}
+
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			logger.Warn("Failed to read log bundle", "path", path, "error", err)
+			continue
+		}
+		var bundle types.LogBundle
+		if err := json.Unmarshal(raw, &bundle); err != nil || bundle.ID == "" {
+			logger.Warn("Ignoring invalid log bundle file", "path", path)
+			continue
+		}
+		if c.path(&bundle, ".json") != path {
+			logger.Warn("Ignoring misplaced log bundle file", "path", path)
+			continue
+		}
+
+		u := &upload{bundle: &bundle, received: make(map[int]bool)}
+		c.uploads[bundle.ID] = u
+		if inProgress(&bundle) {
+			c.fail(u, "upload interrupted by server restart")
+		}
+	}
+	logger.Info("Loaded log bundles", "count", len(c.uploads), "dir", c.dir)
+	return nil
+}
+
+// 租户的保存目录，租户ID转义后作为目录名
+func (c *Collector) tenantDir(tenantID string) string {
+	return filepath.Join(c.dir, strings.ReplaceAll(url.PathEscape(tenantID), ".", "%2E"))
+}
+
+func (c *Collector) path(b *types.LogBundle, ext string) string {
+	return filepath.Join(c.tenantDir(b.TenantID), b.ID+ext)
+}
+
+func inProgress(b *types.LogBundle) bool {
+	return b.Status == types.LogBundleRequested || b.Status == types.LogBundleReceiving
+}
+
+// 写入日志包元数据（先写临时文件再改名），调用方需持有 mutex
+func (c *Collector) save(b *types.LogBundle) {
+	raw, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		logger.Error("Failed to marshal log bundle", "bundle_id", b.ID, "error", err)
+		return
+	}
+	path := c.path(b, ".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		logger.Error("Failed to write log bundle", "bundle_id", b.ID, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logger.Error("Failed to write log bundle", "bundle_id", b.ID, "error", err)
+	}
+}
+
+// 下发 collect_logs 命令并创建等待上传的日志包；params 为已按命令定义校验的参数
+func (c *Collector) Request(ctx context.Context, t *tenant.Tenant, deviceID string, spec *command.Spec, params map[string]interface{}, protocol string) (*types.LogBundle, error) {
+	if err := os.MkdirAll(c.tenantDir(t.ID), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+
+	now := time.Now()
+	bundle := &types.LogBundle{
+		ID:          uuid.New().String(),
+		TenantID:    t.ID,
+		DeviceID:    deviceID,
+		Status:      types.LogBundleRequested,
+		Params:      params,
+		RequestedAt: now,
+		UpdatedAt:   now,
+	}
+
+	// 设备上传时需要的日志包ID和大小限制随命令下发
+	payload := make(map[string]interface{}, len(params)+3)
+	for name, value := range params {
+		payload[name] = value
+	}
+	payload["bundle_id"] = bundle.ID
+	payload["chunk_size"] = schema.MaxLogChunkSize
+	payload["max_bytes"] = c.maxSize
+
+	// 先登记日志包再下发命令，避免设备很快上传的分片找不到日志包
+	c.mutex.Lock()
+	for _, u := range c.uploads {
+		if u.bundle.TenantID == t.ID && u.bundle.DeviceID == deviceID && inProgress(u.bundle) {
+			c.mutex.Unlock()
+			return nil, fmt.Errorf("log collection %s is already in progress for device %s", u.bundle.ID, deviceID)
+		}
+	}
+	c.uploads[bundle.ID] = &upload{bundle: bundle, received: make(map[int]bool)}
+	c.save(bundle)
+	c.mutex.Unlock()
+
+	if err := c.devices.SendCommand(ctx, t.ID, deviceID, spec, payload, protocol); err != nil {
+		c.mutex.Lock()
+		c.remove(bundle)
+		c.mutex.Unlock()
+		return nil, err
+	}
+
+	logger.InfoContext(ctx, "Log collection requested", "tenant_id", t.ID, "device_id", deviceID, "bundle_id", bundle.ID)
+	copied := *bundle
+	return &copied, nil
+}
+
+// 保存设备上传的日志分片，收到全部分片后合并为日志文件；重复投递的分片忽略
+func (c *Collector) HandleChunk(tenantID, deviceID string, chunk *types.LogChunkData) error {
+	data, err := base64.StdEncoding.DecodeString(chunk.Data)
+	if err != nil {
+		return fmt.Errorf("log chunk %d of bundle %s is not valid base64: %v", chunk.Seq, chunk.BundleID, err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	u, ok := c.uploads[chunk.BundleID]
+	if !ok || u.bundle.TenantID != tenantID || u.bundle.DeviceID != deviceID {
+		return fmt.Errorf("unknown log bundle %s for device %s", chunk.BundleID, deviceID)
+	}
+	b := u.bundle
+	if !inProgress(b) {
+		return fmt.Errorf("log bundle %s is %s", b.ID, b.Status)
+	}
+
+	if b.TotalChunks == 0 {
+		maxChunks := int((c.maxSize + schema.MaxLogChunkSize - 1) / schema.MaxLogChunkSize)
+		if chunk.Total > maxChunks {
+			reason := fmt.Sprintf("%d chunks exceed the size limit of %d bytes", chunk.Total, c.maxSize)
+			c.fail(u, reason)
+			return fmt.Errorf("log bundle %s: %s", b.ID, reason)
+		}
+		b.TotalChunks = chunk.Total
+	} else if chunk.Total != b.TotalChunks {
+		return fmt.Errorf("log bundle %s has %d chunks, got chunk with total %d", b.ID, b.TotalChunks, chunk.Total)
+	}
+	if chunk.Seq >= b.TotalChunks {
+		return fmt.Errorf("log chunk %d out of range for bundle %s (%d chunks)", chunk.Seq, b.ID, b.TotalChunks)
+	}
+	if u.received[chunk.Seq] {
+		return nil
+	}
+	if b.Size+int64(len(data)) > c.maxSize {
+		reason := fmt.Sprintf("upload exceeds the size limit of %d bytes", c.maxSize)
+		c.fail(u, reason)
+		return fmt.Errorf("log bundle %s: %s", b.ID, reason)
+	}
+
+	parts := c.path(b, ".parts")
+	if err := os.MkdirAll(parts, 0700); err != nil {
+		return fmt.Errorf("failed to store log chunk: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(parts, strconv.Itoa(chunk.Seq)), data, 0600); err != nil {
+		return fmt.Errorf("failed to store log chunk: %v", err)
+	}
+	u.received[chunk.Seq] = true
+	b.ReceivedChunks = len(u.received)
+	b.Size += int64(len(data))
+	b.Status = types.LogBundleReceiving
+	b.UpdatedAt = time.Now()
+
+	if b.ReceivedChunks == b.TotalChunks {
+		if err := c.assemble(u); err != nil {
+			c.fail(u, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// 按序号合并分片为日志文件并计算摘要，调用方需持有 mutex
+func (c *Collector) assemble(u *upload) error {
+	b := u.bundle
+	parts := c.path(b, ".parts")
+	tmp := c.path(b, ".log.tmp")
+
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %v", err)
+	}
+	hash := sha256.New()
+	w := io.MultiWriter(out, hash)
+	for seq := 0; seq < b.TotalChunks; seq++ {
+		data, err := os.ReadFile(filepath.Join(parts, strconv.Itoa(seq)))
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("failed to assemble log bundle: %v", err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to assemble log bundle: %v", err)
+	}
+	if err := os.Rename(tmp, c.path(b, ".log")); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to assemble log bundle: %v", err)
+	}
+	os.RemoveAll(parts)
+
+	now := time.Now()
+	b.Status = types.LogBundleComplete
+	b.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	b.UpdatedAt = now
+	b.CompletedAt = &now
+	c.save(b)
+	logger.Info("Log bundle complete", "tenant_id", b.TenantID, "device_id", b.DeviceID, "bundle_id", b.ID, "size", b.Size, "chunks", b.TotalChunks)
+
+	c.prune(b.TenantID, b.DeviceID)
+	return nil
+}
+
+// 设备报告无法收集或上传日志（logs_failed）
+func (c *Collector) Fail(tenantID, deviceID, bundleID, reason string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	u, ok := c.uploads[bundleID]
+	if !ok || u.bundle.TenantID != tenantID || u.bundle.DeviceID != deviceID {
+		return fmt.Errorf("unknown log bundle %s for device %s", bundleID, deviceID)
+	}
+	if !inProgress(u.bundle) {
+		return fmt.Errorf("log bundle %s is %s", bundleID, u.bundle.Status)
+	}
+	if reason == "" {
+		reason = "device reported failure"
+	}
+	c.fail(u, reason)
+	return nil
+}
+
+// 标记日志包失败并删除已收到的分片，调用方需持有 mutex
+func (c *Collector) fail(u *upload, reason string) {
+	b := u.bundle
+	b.Status = types.LogBundleFailed
+	b.Error = reason
+	b.UpdatedAt = time.Now()
+	os.RemoveAll(c.path(b, ".parts"))
+	c.save(b)
+	logger.Warn("Log bundle failed", "tenant_id", b.TenantID, "device_id", b.DeviceID, "bundle_id", b.ID, "reason", reason)
+
+	c.prune(b.TenantID, b.DeviceID)
+}
+
+// 删除日志包及其文件，调用方需持有 mutex
+func (c *Collector) remove(b *types.LogBundle) {
+	for _, ext := range []string{".json", ".log", ".parts"} {
+		if err := os.RemoveAll(c.path(b, ext)); err != nil {
+			logger.Error("Failed to remove log bundle", "bundle_id", b.ID, "error", err)
+		}
+	}
+	delete(c.uploads, b.ID)
+}
+
+// 只保留设备最近的已结束日志包，调用方需持有 mutex
+func (c *Collector) prune(tenantID, deviceID string) {
+	var finished []*types.LogBundle
+	for _, u := range c.uploads {
+		if u.bundle.TenantID == tenantID && u.bundle.DeviceID == deviceID && !inProgress(u.bundle) {
+			finished = append(finished, u.bundle)
+		}
+	}
+	if len(finished) <= maxBundlesPerDevice {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].RequestedAt.Before(finished[j].RequestedAt)
+	})
+	for _, b := range finished[:len(finished)-maxBundlesPerDevice] {
+		c.remove(b)
+	}
+}
+
+// 设备的日志包（副本），最新的在前
+func (c *Collector) List(tenantID, deviceID string) []*types.LogBundle {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	result := make([]*types.LogBundle, 0)
+	for _, u := range c.uploads {
+		if u.bundle.TenantID == tenantID && u.bundle.DeviceID == deviceID {
+			copied := *u.bundle
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RequestedAt.After(result[j].RequestedAt)
+	})
+	return result
+}
+
+// 获取日志包（副本）
+func (c *Collector) Get(tenantID, deviceID, bundleID string) (*types.LogBundle, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	u, ok := c.uploads[bundleID]
+	if !ok || u.bundle.TenantID != tenantID || u.bundle.DeviceID != deviceID {
+		return nil, false
+	}
+	copied := *u.bundle
+	return &copied, true
+}
+
+// 打开已完成日志包的日志文件
+func (c *Collector) Open(tenantID, deviceID, bundleID string) (*os.File, *types.LogBundle, error) {
+	bundle, ok := c.Get(tenantID, deviceID, bundleID)
+	if !ok {
+		return nil, nil, fmt.Errorf("log bundle not found: %s", bundleID)
+	}
+	if bundle.Status != types.LogBundleComplete {
+		return nil, bundle, fmt.Errorf("log bundle %s is %s", bundleID, bundle.Status)
+	}
+	f, err := os.Open(c.path(bundle, ".log"))
+	if err != nil {
+		return nil, bundle, fmt.Errorf("failed to open log bundle %s: %v", bundleID, err)
+	}
+	return f, bundle, nil
+}
+
+// 启动清理协程：上传超时的日志包标记为失败，超过保留期限的日志包删除
+func (c *Collector) StartCleanup() {
+	ticker := time.NewTicker(60 * time.Second) // 每分钟检查一次
+	c.cleanupStop = make(chan struct{})
+	c.cleanupDone = make(chan struct{})
+	go func() {
+		defer close(c.cleanupDone)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.cleanup(time.Now())
+			case <-c.cleanupStop:
+				return
+			}
+		}
+	}()
+}
+
+// 停止清理协程；上传中的日志包已保存在磁盘上，下次启动时标记为中断
+func (c *Collector) Close() {
+	if c.cleanupStop != nil {
+		close(c.cleanupStop)
+		<-c.cleanupDone
+		c.cleanupStop = nil
+	}
+}
+
+// 设置已完成日志包的保留时长（0表示保留到被替换）
+func (c *Collector) SetRetention(retention time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.retention = retention
+}
+
+func (c *Collector) cleanup(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, u := range c.uploads {
+		b := u.bundle
+		switch {
+		case inProgress(b) && now.Sub(b.UpdatedAt) > uploadTimeout:
+			c.fail(u, fmt.Sprintf("no chunk received for %s", uploadTimeout))
+		case !inProgress(b) && c.retention > 0 && now.Sub(b.UpdatedAt) > c.retention:
+			logger.Info("Log bundle expired", "tenant_id", b.TenantID, "device_id", b.DeviceID, "bundle_id", b.ID)
+			c.remove(b)
+		}
+	}
+}
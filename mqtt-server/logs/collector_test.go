@@ -0,0 +1,180 @@
+package logs
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"mobile-admin-mqtt-server/schema"
+	"mobile-admin-mqtt-server/types"
+)
+
+// 设备上传的分片：data 为原始内容（base64编码后上传），err 为期望的错误（为空表示成功）
+type chunk struct {
+	seq, total int
+	data       string
+	// 直接作为上传内容，不做base64编码
+	raw bool
+	err string
+}
+
+func TestHandleChunk(t *testing.T) {
+	full := strings.Repeat("x", schema.MaxLogChunkSize)
+
+	tests := []struct {
+		name    string
+		chunks  []chunk
+		status  string
+		content string
+	}{
+		{
+			name:    "single chunk",
+			chunks:  []chunk{{seq: 0, total: 1, data: "hello"}},
+			status:  types.LogBundleComplete,
+			content: "hello",
+		},
+		{
+			name:    "out of order",
+			chunks:  []chunk{{seq: 1, total: 2, data: "world"}, {seq: 0, total: 2, data: "hello "}},
+			status:  types.LogBundleComplete,
+			content: "hello world",
+		},
+		{
+			name:    "duplicate chunk ignored",
+			chunks:  []chunk{{seq: 0, total: 2, data: "a"}, {seq: 0, total: 2, data: "a"}, {seq: 1, total: 2, data: "b"}},
+			status:  types.LogBundleComplete,
+			content: "ab",
+		},
+		{
+			name:   "partial upload",
+			chunks: []chunk{{seq: 0, total: 2, data: "a"}},
+			status: types.LogBundleReceiving,
+		},
+		{
+			name:   "too many chunks",
+			chunks: []chunk{{seq: 0, total: 3, data: "a", err: "exceed the size limit"}},
+			status: types.LogBundleFailed,
+		},
+		{
+			name:   "size limit exceeded",
+			chunks: []chunk{{seq: 0, total: 2, data: full}, {seq: 1, total: 2, data: full, err: "exceeds the size limit"}},
+			status: types.LogBundleFailed,
+		},
+		{
+			name:   "total changed",
+			chunks: []chunk{{seq: 0, total: 2, data: "a"}, {seq: 1, total: 1, data: "b", err: "has 2 chunks"}},
+			status: types.LogBundleReceiving,
+		},
+		{
+			name:   "seq out of range",
+			chunks: []chunk{{seq: 2, total: 2, data: "a", err: "out of range"}},
+			status: types.LogBundleRequested,
+		},
+		{
+			name:   "invalid base64",
+			chunks: []chunk{{seq: 0, total: 1, data: "!!", raw: true, err: "not valid base64"}},
+			status: types.LogBundleRequested,
+		},
+		{
+			name:    "chunk after completion",
+			chunks:  []chunk{{seq: 0, total: 1, data: "a"}, {seq: 0, total: 1, data: "a", err: "is complete"}},
+			status:  types.LogBundleComplete,
+			content: "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 最多两个分片，且不足两个满分片
+			c, err := NewCollector(nil, t.TempDir(), schema.MaxLogChunkSize+8, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bundle := &types.LogBundle{
+				ID:          "bundle-1",
+				TenantID:    "default",
+				DeviceID:    "phone-01",
+				Status:      types.LogBundleRequested,
+				RequestedAt: time.Now(),
+				UpdatedAt:   time.Now(),
+			}
+			if err := os.MkdirAll(c.tenantDir(bundle.TenantID), 0700); err != nil {
+				t.Fatal(err)
+			}
+			c.uploads[bundle.ID] = &upload{bundle: bundle, received: make(map[int]bool)}
+
+			for _, ch := range tt.chunks {
+				data := ch.data
+				if !ch.raw {
+					data = base64.StdEncoding.EncodeToString([]byte(ch.data))
+				}
+				err := c.HandleChunk(bundle.TenantID, bundle.DeviceID, &types.LogChunkData{BundleID: bundle.ID, Seq: ch.seq, Total: ch.total, Data: data})
+				switch {
+				case ch.err == "" && err != nil:
+					t.Fatalf("chunk %d: %v", ch.seq, err)
+				case ch.err != "" && (err == nil || !strings.Contains(err.Error(), ch.err)):
+					t.Fatalf("chunk %d: error %v, want %q", ch.seq, err, ch.err)
+				}
+			}
+
+			got, ok := c.Get(bundle.TenantID, bundle.DeviceID, bundle.ID)
+			if !ok {
+				t.Fatal("bundle not found")
+			}
+			if got.Status != tt.status {
+				t.Fatalf("status %s, want %s (error %q)", got.Status, tt.status, got.Error)
+			}
+			if tt.status != types.LogBundleComplete {
+				return
+			}
+			content, err := os.ReadFile(c.path(got, ".log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.content {
+				t.Errorf("content %q, want %q", content, tt.content)
+			}
+			sum := sha256.Sum256([]byte(tt.content))
+			if got.SHA256 != hex.EncodeToString(sum[:]) || got.Size != int64(len(tt.content)) {
+				t.Errorf("sha256 %s size %d, want %x size %d", got.SHA256, got.Size, sum, len(tt.content))
+			}
+			if _, err := os.Stat(c.path(got, ".parts")); !os.IsNotExist(err) {
+				t.Errorf("chunk directory was not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnknownBundle(t *testing.T) {
+	c, err := NewCollector(nil, t.TempDir(), schema.MaxLogChunkSize, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.uploads["bundle-1"] = &upload{
+		bundle:   &types.LogBundle{ID: "bundle-1", TenantID: "default", DeviceID: "phone-01", Status: types.LogBundleRequested},
+		received: make(map[int]bool),
+	}
+
+	tests := []struct {
+		name     string
+		tenantID string
+		deviceID string
+		bundleID string
+	}{
+		{name: "unknown bundle", tenantID: "default", deviceID: "phone-01", bundleID: "bundle-2"},
+		{name: "other device", tenantID: "default", deviceID: "phone-02", bundleID: "bundle-1"},
+		{name: "other tenant", tenantID: "acme", deviceID: "phone-01", bundleID: "bundle-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.HandleChunk(tt.tenantID, tt.deviceID, &types.LogChunkData{BundleID: tt.bundleID, Total: 1, Data: "YQ=="})
+			if err == nil || !strings.Contains(err.Error(), "unknown log bundle") {
+				t.Errorf("error %v, want unknown log bundle", err)
+			}
+		})
+	}
+}
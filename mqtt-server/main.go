@@ -1,119 +1,401 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"mobile-admin-mqtt-server/api"
+	"mobile-admin-mqtt-server/artifacts"
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/config"
+	"mobile-admin-mqtt-server/logging"
+	"mobile-admin-mqtt-server/logs"
 	"mobile-admin-mqtt-server/mqtt"
+	"mobile-admin-mqtt-server/rotation"
+	"mobile-admin-mqtt-server/signing"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/topics"
+	"mobile-admin-mqtt-server/tracing"
 	"mobile-admin-mqtt-server/types"
 
+	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gorilla/mux"
 )
 
-// 获取环境变量，如果不存在则使用默认值
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+var logger = logging.Logger("server")
+
+// 记录错误并退出
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// 运行中的组件，重新加载配置时更新，退出时按顺序关闭
+type components struct {
+	registry     *tenant.Registry
+	mqttHandler  *mqtt.Handler
+	logCollector *logs.Collector
+
+	server          *http.Server
+	rotations       *rotation.Runner
+	shutdownTracing func(context.Context) error
+}
+
+// 应用可在运行时修改的配置（启动时和收到 SIGHUP 时调用）
+func (c *components) apply(cfg *config.Config) error {
+	c.mqttHandler.GetDeviceManager().SetTimeouts(cfg.Devices.Timeout, cfg.Devices.RemoveAfter)
+	c.registry.SetDefaultMaxDevices(cfg.Devices.MaxDevices)
+	c.logCollector.SetRetention(cfg.DeviceLogs.Retention)
+
+	// 单租户模式的API凭证；多租户模式使用租户文件中的 api_key
+	if cfg.TenantsFile == "" {
+		var keys []string
+		if cfg.Auth.Enabled {
+			keys = cfg.Auth.APIKeys
+		}
+		if err := c.registry.SetAPIKeys(tenant.DefaultID, keys); err != nil {
+			return err
+		}
 	}
-	return defaultValue
+	return nil
 }
 
-// 获取环境变量整数值
-func getEnvIntOrDefault(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// 按顺序关闭：停止接收HTTP请求并等待处理中的请求和换IP任务（最多 timeout），
+// 停止清理协程，发布服务端离线状态并断开MQTT，写回准入数据，最后导出追踪并关闭日志文件
+func (c *components) shutdown(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// 不再接受新连接，等待处理中的请求（包括正在下发的命令）返回
+	if err := c.server.Shutdown(ctx); err != nil {
+		logger.Warn("HTTP requests still in flight at shutdown deadline, closing connections", "error", err)
+		c.server.Close()
+	}
+	c.rotations.Shutdown(ctx)
+
+	deviceManager := c.mqttHandler.GetDeviceManager()
+	c.logCollector.Close()
+	deviceManager.StopCleanup()
+	c.mqttHandler.Disconnect()
+	deviceManager.Close()
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := c.shutdownTracing(flushCtx); err != nil {
+		logger.Warn("Failed to flush traces", "error", err)
+	}
+
+	logger.Info("Server stopped")
+	logging.Close()
+}
+
+// 重新加载配置文件和环境变量，只应用可在运行时修改的配置
+func reload(loader *config.Loader, cfg *config.Config, c *components) *config.Config {
+	next, err := loader.Load()
+	if err != nil {
+		logger.Error("Config reload failed, keeping current configuration", "error", err)
+		return cfg
+	}
+	for _, s := range config.KeepRestartOnly(cfg, next) {
+		logger.Warn("Config changed but requires a restart to take effect", "key", s.Key)
+	}
+	if err := next.Validate(); err != nil {
+		logger.Error("Config reload failed, keeping current configuration", "error", err)
+		return cfg
+	}
+
+	if err := logging.Configure(next.Logging()); err != nil {
+		logger.Error("Failed to apply logging configuration, keeping current log output", "error", err)
+		next.Log = cfg.Log
+	}
+	if err := c.apply(next); err != nil {
+		logger.Error("Config reload failed", "error", err)
+		return cfg
+	}
+
+	changed := []string{"none"}
+	if settings := config.Changes(cfg, next); len(settings) > 0 {
+		changed = changed[:0]
+		for _, s := range settings {
+			changed = append(changed, s.Key)
 		}
 	}
-	return defaultValue
+	logger.Info("Configuration reloaded", "changed", strings.Join(changed, ", "))
+	return next
 }
 
 func main() {
-	// 命令行参数（优先级高于环境变量）
-	var (
-		broker   = flag.String("broker", getEnvOrDefault("MQTT_BROKER", "121.199.162.193"), "MQTT broker hostname")
-		port     = flag.Int("port", getEnvIntOrDefault("MQTT_PORT", 1888), "MQTT broker port")
-		username = flag.String("username", getEnvOrDefault("MQTT_USERNAME", ""), "MQTT username")
-		password = flag.String("password", getEnvOrDefault("MQTT_PASSWORD", ""), "MQTT password")
-		httpPort = flag.String("http-port", getEnvOrDefault("HTTP_PORT", "8080"), "HTTP API server port")
-	)
+	// 配置优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+	loader := config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	cfg, err := loader.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if err := logging.Configure(cfg.Logging()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure logging: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	// MQTT客户端库的日志，级别由 paho 子系统控制
+	pahoLogger := logging.Logger("paho")
+	paho.DEBUG = logging.Printer{Logger: pahoLogger, Level: slog.LevelDebug}
+	// 未保存订阅（ResumeSubs关闭）时每个SUBACK都会触发 "memorystore del ... not found"，属正常情况
+	paho.WARN = logging.Printer{Logger: pahoLogger, Level: slog.LevelWarn, Routine: []string{"memorystore del: message"}}
+	paho.ERROR = logging.Printer{Logger: pahoLogger, Level: slog.LevelError}
+	paho.CRITICAL = logging.Printer{Logger: pahoLogger, Level: slog.LevelError}
+
+	// 请求追踪，关闭时导出剩余的span
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingOptions())
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
 	// 创建MQTT配置
-	config := &types.MQTTConfig{
-		Broker:   *broker,
-		Port:     *port,
-		Username: *username,
-		Password: *password,
+	mqttConfig := &types.MQTTConfig{
+		Broker:   cfg.MQTT.Broker,
+		Port:     cfg.MQTT.Port,
+		Username: cfg.MQTT.Username,
+		Password: cfg.MQTT.Password,
+
+		TopicPrefix:    cfg.MQTT.TopicPrefix,
+		TopicTemplates: cfg.MQTT.Topics,
+	}
+
+	// 加载租户配置，未指定租户文件时为单租户模式
+	var registry *tenant.Registry
+	if cfg.TenantsFile != "" {
+		registry, err = tenant.LoadRegistry(cfg.TenantsFile, mqttConfig.TopicPrefix, mqttConfig.TopicTemplates)
+	} else {
+		registry, err = tenant.NewDefaultRegistry(mqttConfig.TopicPrefix, mqttConfig.TopicTemplates)
+	}
+	if err != nil {
+		fatal("Failed to load tenants", err)
+	}
+	if err := registry.SetDefaultEnrollment(cfg.Enrollment.Mode); err != nil {
+		fatal("Invalid enrollment mode", err)
+	}
+
+	// 加载命令注册表（内置命令 + 可选的自定义命令文件）
+	commandRegistry := command.NewDefaultRegistry()
+	if cfg.CommandsFile != "" {
+		if err := commandRegistry.LoadFile(cfg.CommandsFile); err != nil {
+			fatal("Failed to load commands", err)
+		}
+	}
+
+	// 命令签名密钥环
+	signer, err := signing.NewKeyring(cfg.Signing.Mode, cfg.Signing.TTL)
+	if err != nil {
+		fatal("Invalid command signing configuration", err)
+	}
+	if cfg.Signing.KeyFile != "" {
+		if err := signer.Load(cfg.Signing.KeyFile); err != nil {
+			fatal("Failed to load signing keys", err)
+		}
+	} else if signer.Mode() != types.SigningOff {
+		logger.Warn("No signing key file configured, signing keys will change on restart")
 	}
 
 	// 初始化MQTT处理器
-	mqttHandler, err := mqtt.NewHandler(config)
+	mqttHandler, err := mqtt.NewHandler(mqttConfig, registry)
 	if err != nil {
-		log.Fatalf("Failed to create MQTT handler: %v", err)
+		fatal("Failed to create MQTT handler", err)
+	}
+
+	mqttHandler.GetDeviceManager().SetSigner(signer)
+
+	// 加载预配置和待审批的设备
+	if cfg.Enrollment.File != "" {
+		if err := mqttHandler.GetDeviceManager().LoadEnrollment(cfg.Enrollment.File); err != nil {
+			fatal("Failed to load enrollment", err)
+		}
+	}
+
+	// 加载设备配置影子
+	if cfg.Devices.ConfigFile != "" {
+		if err := mqttHandler.GetDeviceManager().LoadConfigs(cfg.Devices.ConfigFile); err != nil {
+			fatal("Failed to load device configs", err)
+		}
 	}
-	defer mqttHandler.Disconnect()
 
 	// 启动设备清理协程
 	mqttHandler.GetDeviceManager().StartCleanup()
 
+	// 设备日志收集器
+	logCollector, err := logs.NewCollector(mqttHandler.GetDeviceManager(), cfg.DeviceLogs.Dir, int64(cfg.DeviceLogs.MaxMB)*1024*1024, cfg.DeviceLogs.Retention)
+	if err != nil {
+		fatal("Failed to create log collector", err)
+	}
+	mqttHandler.SetLogCollector(logCollector)
+	logCollector.StartCleanup()
+
+	// 应用制品库
+	artifactStore, err := artifacts.NewStore(mqttHandler.GetDeviceManager(), cfg.Artifacts.Dir, int64(cfg.Artifacts.MaxMB)*1024*1024)
+	if err != nil {
+		fatal("Failed to create artifact store", err)
+	}
+
+	// 应用可在运行时修改的配置
+	running := &components{
+		registry:     registry,
+		mqttHandler:  mqttHandler,
+		logCollector: logCollector,
+	}
+	if err := running.apply(cfg); err != nil {
+		fatal("Failed to apply configuration", err)
+	}
+
+	// 启动换IP任务执行器
+	rotations := rotation.NewRunner(mqttHandler.GetDeviceManager(), commandRegistry)
+
 	// 创建HTTP API处理器
-	apiHandler := api.NewHandler(mqttHandler.GetDeviceManager())
+	apiHandler := api.NewHandler(mqttHandler.GetDeviceManager(), commandRegistry, rotations)
 	apiHandler.SetMQTTClient(mqttHandler.GetMQTTClient())
+	apiHandler.SetSigner(signer)
+	apiHandler.SetValidator(mqttHandler.GetValidator())
+	apiHandler.SetLogCollector(logCollector)
+	apiHandler.SetArtifactStore(artifactStore, cfg.HTTP.PublicURL)
 
 	// 设置HTTP路由
 	router := mux.NewRouter()
 
-	// 应用CORS中间件
+	// 追踪、请求ID和访问日志，然后是CORS中间件
+	router.Use(apiHandler.TracingMiddleware)
+	router.Use(apiHandler.LoggingMiddleware)
 	router.Use(apiHandler.CORSMiddleware)
 
 	// API路由
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
 	apiRouter.HandleFunc("/health", apiHandler.HealthCheck).Methods("GET", "OPTIONS")
-	apiRouter.HandleFunc("/devices", apiHandler.GetDevices).Methods("GET", "OPTIONS")
-	apiRouter.HandleFunc("/devices/{id}", apiHandler.GetDevice).Methods("GET", "OPTIONS")
-	apiRouter.HandleFunc("/command", apiHandler.SendCommand).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/messages/schemas", apiHandler.GetMessageSchemas).Methods("GET", "OPTIONS")
+	// 设备下载APK，使用签名链接而非租户凭证
+	apiRouter.HandleFunc("/downloads/apps/{tenant}/{code}", apiHandler.DownloadApp).Methods("GET")
+
+	// 需要租户凭证的路由
+	tenantRouter := apiRouter.NewRoute().Subrouter()
+	tenantRouter.Use(apiHandler.TenantMiddleware)
+	tenantRouter.HandleFunc("/devices", apiHandler.GetDevices).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/devices/{id}", apiHandler.GetDevice).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/devices/{id}", apiHandler.UpdateDevice).Methods("PATCH")
+	tenantRouter.HandleFunc("/devices/{id}", apiHandler.DeleteDevice).Methods("DELETE")
+	tenantRouter.HandleFunc("/devices/{id}/ips", apiHandler.GetDeviceIPs).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/devices/{id}/config", apiHandler.GetDeviceConfig).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/devices/{id}/config", apiHandler.SetDeviceConfig).Methods("PUT")
+	tenantRouter.HandleFunc("/devices/{id}/logs", apiHandler.GetDeviceLogs).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/devices/{id}/logs/{bundle}", apiHandler.GetDeviceLog).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/command", apiHandler.SendCommand).Methods("POST", "OPTIONS")
+	tenantRouter.HandleFunc("/commands/catalog", apiHandler.GetCommandCatalog).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/apps/releases", apiHandler.GetAppReleases).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/apps/releases", apiHandler.UploadAppRelease).Methods("POST")
+	tenantRouter.HandleFunc("/apps/releases/{code}", apiHandler.GetAppRelease).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/apps/releases/{code}", apiHandler.DeleteAppRelease).Methods("DELETE")
+	tenantRouter.HandleFunc("/apps/rollout", apiHandler.GetAppRollout).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/metrics", apiHandler.Metrics).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/messages/rejects", apiHandler.GetMessageRejects).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/enrollment/devices", apiHandler.GetEnrolledDevices).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/enrollment/devices", apiHandler.ProvisionDevice).Methods("POST")
+	tenantRouter.HandleFunc("/enrollment/devices/{id}", apiHandler.RevokeDevice).Methods("DELETE", "OPTIONS")
+	tenantRouter.HandleFunc("/enrollment/devices/{id}/token", apiHandler.RotateDeviceToken).Methods("POST", "OPTIONS")
+	tenantRouter.HandleFunc("/enrollment/pending", apiHandler.GetPendingDevices).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/enrollment/pending/{id}", apiHandler.DismissPendingDevice).Methods("DELETE", "OPTIONS")
+	tenantRouter.HandleFunc("/enrollment/pending/{id}/approve", apiHandler.ApprovePendingDevice).Methods("POST", "OPTIONS")
+	tenantRouter.HandleFunc("/signing/keys", apiHandler.GetSigningKeys).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/signing/keys/rotate", apiHandler.RotateSigningKey).Methods("POST", "OPTIONS")
+	tenantRouter.HandleFunc("/signing/keys/{kid}", apiHandler.DeleteSigningKey).Methods("DELETE", "OPTIONS")
+	tenantRouter.HandleFunc("/rotations", apiHandler.GetRotations).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/rotations/{id}", apiHandler.GetRotation).Methods("GET", "OPTIONS")
+	tenantRouter.HandleFunc("/rotations/{id}", apiHandler.CancelRotation).Methods("DELETE")
 
 	// 静态文件服务（可选）
 	router.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("./static/"))))
 
 	// 启动HTTP服务器
-	log.Printf("Starting MQTT Server...")
-	log.Printf("MQTT Broker: %s:%d", config.Broker, config.Port)
-	log.Printf("HTTP API Server: http://localhost:%s", *httpPort)
-	log.Printf("API Endpoints:")
-	log.Printf("  GET  /api/v1/health")
-	log.Printf("  GET  /api/v1/devices")
-	log.Printf("  GET  /api/v1/devices/{id}")
-	log.Printf("  POST /api/v1/command")
-	log.Printf("")
-	log.Printf("MQTT Topics:")
-	log.Printf("  Subscribe: %s", types.TopicDeviceRegister)
-	log.Printf("  Subscribe: %s", types.TopicDeviceStatus)
-	log.Printf("  Subscribe: %s", types.TopicDeviceHeartbeat)
-	log.Printf("  Subscribe: %s", types.TopicDeviceOffline)
-	log.Printf("  Publish:   %s/{device_id}", types.TopicCommandPrefix)
-	log.Printf("  Subscribe: %s/{device_id}", types.TopicResponsePrefix)
-
-	// 设置优雅关闭
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	logger.Info("Starting MQTT Server",
+		"config_file", loader.Path(),
+		"broker", fmt.Sprintf("%s:%d", mqttConfig.Broker, mqttConfig.Port),
+		"http", "http://localhost:"+cfg.HTTP.Port,
+	)
+	logger.Info("Command signing", "mode", signer.Mode(), "ttl", cfg.Signing.TTL)
+	logger.Info("Device timeouts", "offline_after", cfg.Devices.Timeout, "remove_after", cfg.Devices.RemoveAfter)
+	logger.Info("Device logs", "dir", cfg.DeviceLogs.Dir, "max_mb", cfg.DeviceLogs.MaxMB, "retention", cfg.DeviceLogs.Retention)
+	logger.Info("App artifacts", "dir", cfg.Artifacts.Dir, "max_mb", cfg.Artifacts.MaxMB)
+	logger.Info("Tracing", "exporter", cfg.Tracing.Exporter, "endpoint", cfg.Tracing.Endpoint, "sample_ratio", cfg.Tracing.SampleRatio)
+
+	// 路由和主题列表只在 debug 级别输出
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(path, "/api/") {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		logger.Debug("API endpoint", "methods", strings.Join(methods, ","), "path", path)
+		return nil
+	})
+	logger.Debug("MQTT topic", "direction", "publish", "topic", mqttHandler.GetTopics().Pattern(topics.NameServerStatus), "note", "retained, LWT")
+	for _, t := range registry.All() {
+		logger.Info("Tenant", "tenant_id", t.ID, "prefix", t.Topics.Prefix(), "enrollment", t.Enrollment)
+		subscribe := []string{topics.NameDeviceRegister, topics.NameDeviceStatus, topics.NameDeviceHeartbeat, topics.NameDeviceOffline, topics.NameResponse}
+		subscribe = append(subscribe, mqttHandler.GetDeviceManager().StatusTopics()...)
+		subscribe = append(subscribe, topics.NameDeviceWill, topics.NameConfigReported, topics.NameLogUpload)
+		for _, name := range subscribe {
+			logger.Debug("MQTT topic", "tenant_id", t.ID, "direction", "subscribe", "topic", t.Topics.Pattern(name))
+		}
+		for _, name := range []string{topics.NameCommand, topics.NameAndroidCommand, topics.NameDeviceState, topics.NameConfigDelta} {
+			logger.Debug("MQTT topic", "tenant_id", t.ID, "direction", "publish", "topic", t.Topics.Pattern(name))
+		}
+	}
+
+	// 收到 SIGHUP 时重新加载配置
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func(current *config.Config) {
+		for range hup {
+			current = reload(loader, current, running)
+		}
+	}(cfg)
+
+	// 启动HTTP服务器
+	server := &http.Server{Addr: ":" + cfg.HTTP.Port, Handler: router}
+	running.server = server
+	running.rotations = rotations
+	running.shutdownTracing = shutdownTracing
+
+	// 收到 SIGINT/SIGTERM 时优雅关闭，关闭过程中再次收到则立即退出
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	serveErr := make(chan error, 1)
 	go func() {
-		<-c
-		log.Println("Shutting down server...")
-		mqttHandler.Disconnect()
-		os.Exit(0)
+		serveErr <- server.ListenAndServe()
 	}()
 
-	// 启动HTTP服务器
-	if err := http.ListenAndServe(":"+*httpPort, router); err != nil {
-		log.Fatalf("HTTP server failed to start: %v", err)
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		logger.Error("HTTP server failed", "error", err)
+		exitCode = 1
+	case sig := <-stop:
+		logger.Info("Shutting down server", "signal", sig.String(), "timeout", cfg.ShutdownTimeout)
 	}
+
+	go func() {
+		<-stop
+		logger.Warn("Received second signal, exiting without waiting for shutdown")
+		os.Exit(1)
+	}()
+
+	running.shutdown(cfg.ShutdownTimeout)
+	os.Exit(exitCode)
 }
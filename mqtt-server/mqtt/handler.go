@@ -1,17 +1,32 @@
 package mqtt
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"strings"
+	"sort"
 	"time"
 
 	"mobile-admin-mqtt-server/device"
+	"mobile-admin-mqtt-server/logging"
+	"mobile-admin-mqtt-server/logs"
+	"mobile-admin-mqtt-server/schema"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/topics"
+	"mobile-admin-mqtt-server/tracing"
 	"mobile-admin-mqtt-server/types"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	logger = logging.Logger("mqtt")
+	tracer = tracing.Tracer("mqtt")
 )
 
 // MQTT处理器
@@ -19,15 +34,28 @@ type Handler struct {
 	client        mqtt.Client
 	deviceManager *device.Manager
 	config        *types.MQTTConfig
+	// 服务端级别的主题（如服务端状态），不属于任何租户
+	topics  *topics.Tree
+	tenants *tenant.Registry
+	// 入站消息校验
+	validator *schema.Validator
+	// 设备日志收集，为空时忽略上传的日志分片
+	logCollector *logs.Collector
 }
 
 // 创建新的MQTT处理器
-func NewHandler(config *types.MQTTConfig) (*Handler, error) {
+func NewHandler(config *types.MQTTConfig, tenants *tenant.Registry) (*Handler, error) {
 	// 生成唯一的客户端ID
 	if config.ClientID == "" {
 		config.ClientID = fmt.Sprintf("mqtt-server-%s", uuid.New().String()[:8])
 	}
 
+	// 构建主题树（根前缀 + 主题模板）
+	tree, err := topics.NewTree(config.TopicPrefix, config.TopicTemplates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic configuration: %v", err)
+	}
+
 	// 创建MQTT客户端选项
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", config.Broker, config.Port))
@@ -45,14 +73,18 @@ func NewHandler(config *types.MQTTConfig) (*Handler, error) {
 	opts.SetKeepAlive(30 * time.Second)
 	opts.SetPingTimeout(10 * time.Second)
 
+	// 设置服务端遗嘱，异常断开时由broker发布离线状态
+	opts.SetBinaryWill(tree.ServerStatus(), serverStatusPayload(config.ClientID, types.ServerStatusOffline), 1, true)
+
 	// 设置连接丢失处理器
 	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-		log.Printf("MQTT connection lost: %v", err)
+		logger.Warn("MQTT connection lost", "error", err)
 	})
 
 	// 设置重连处理器
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
-		log.Println("MQTT client connected")
+		logger.Info("MQTT client connected")
+		publishServerStatus(client, tree.ServerStatus(), config.ClientID, types.ServerStatusOnline)
 	})
 
 	// 创建客户端
@@ -64,42 +96,53 @@ func NewHandler(config *types.MQTTConfig) (*Handler, error) {
 	}
 
 	handler := &Handler{
-		client: client,
-		config: config,
+		client:    client,
+		config:    config,
+		topics:    tree,
+		tenants:   tenants,
+		validator: schema.NewValidator(),
 	}
 
 	// 创建设备管理器
-	handler.deviceManager = device.NewManager(client)
+	handler.deviceManager = device.NewManager(client, tenants)
 
 	// 订阅主题
 	if err := handler.subscribeTopics(); err != nil {
 		return nil, err
 	}
 
-	log.Printf("MQTT Handler initialized with broker: %s:%d", config.Broker, config.Port)
+	logger.Info("MQTT handler initialized", "broker", fmt.Sprintf("%s:%d", config.Broker, config.Port), "client_id", config.ClientID)
 	return handler, nil
 }
 
-// 订阅MQTT主题
+// 订阅MQTT主题（每个租户的命名空间各订阅一份）
 func (h *Handler) subscribeTopics() error {
-	topics := map[string]byte{
-		types.TopicDeviceRegister:  1,
-		types.TopicDeviceStatus:    1,
-		types.TopicDeviceHeartbeat: 1,
-		types.TopicDeviceOffline:   1,
-		// 订阅所有设备的响应主题
-		fmt.Sprintf("%s/+", types.TopicResponsePrefix): 1,
-		// 订阅Android客户端主题格式: device/+/restart4g
-		fmt.Sprintf("%s/+/restart4g", types.TopicAndroidDevicePrefix): 1,
-		// 订阅Android客户端状态主题: device/+/status  
-		fmt.Sprintf("%s/+/status", types.TopicAndroidDevicePrefix): 1,
-	}
-
-	for topic, qos := range topics {
-		if token := h.client.Subscribe(topic, qos, h.messageHandler); token.Wait() && token.Error() != nil {
-			return fmt.Errorf("failed to subscribe to topic %s: %v", topic, token.Error())
+	for _, t := range h.tenants.All() {
+		subscriptions := map[string]byte{
+			t.Topics.Filter(topics.NameDeviceRegister):  1,
+			t.Topics.Filter(topics.NameDeviceStatus):    1,
+			t.Topics.Filter(topics.NameDeviceHeartbeat): 1,
+			t.Topics.Filter(topics.NameDeviceOffline):   1,
+			// 订阅所有设备的响应主题
+			t.Topics.Filter(topics.NameResponse): 1,
+			// 订阅设备遗嘱主题: device/will/+
+			t.Topics.Filter(topics.NameDeviceWill): 1,
+			// 订阅设备上报的配置: device/config/+/reported
+			t.Topics.Filter(topics.NameConfigReported): 1,
+			// 订阅设备上传的日志分片: device/logs/+
+			t.Topics.Filter(topics.NameLogUpload): 1,
+		}
+		// 订阅各设备族适配器的状态主题，如 device/+/status、modem/+/state
+		for _, statusTopic := range h.deviceManager.StatusTopics() {
+			subscriptions[t.Topics.Filter(statusTopic)] = 1
+		}
+
+		for topic, qos := range subscriptions {
+			if token := h.client.Subscribe(topic, qos, h.messageHandler); token.Wait() && token.Error() != nil {
+				return fmt.Errorf("failed to subscribe to topic %s: %v", topic, token.Error())
+			}
+			logger.Debug("Subscribed to topic", "tenant_id", t.ID, "topic", topic)
 		}
-		log.Printf("Subscribed to topic: %s", topic)
 	}
 
 	return nil
@@ -110,167 +153,408 @@ func (h *Handler) messageHandler(client mqtt.Client, msg mqtt.Message) {
 	topic := msg.Topic()
 	payload := msg.Payload()
 
-	log.Printf("Received message on topic: %s", topic)
+	// 设备响应中带回命令的 traceparent 时，消息处理归入下发命令的追踪
+	ctx, span := tracer.Start(tracing.ExtractPayload(context.Background(), payload), "receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("mqtt"),
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingMessageBodySize(len(payload)),
+		))
+	defer span.End()
+
+	logger.Log(ctx, logging.LevelTrace, "Received message", "topic", topic, "bytes", len(payload))
+
+	// 根据主题命名空间确定所属租户
+	t, ok := h.tenants.ByTopic(topic)
+	if !ok {
+		logger.Warn("No tenant owns topic", "topic", topic)
+		return
+	}
+	span.SetAttributes(attribute.String("tenant_id", t.ID))
+
+	// 遗嘱消息由broker代发，载荷可能是任意格式，优先按主题处理
+	if vars, ok := t.Topics.Match(topics.NameDeviceWill, topic); ok {
+		span.SetName("receive " + topics.NameDeviceWill)
+		span.SetAttributes(attribute.String("device_id", vars[topics.VarDeviceID]))
+		h.handleDeviceWill(t, vars[topics.VarDeviceID])
+		return
+	}
 
-	// 检查是否是Android客户端的主题格式
-	if h.isAndroidClientTopic(topic) {
-		h.handleAndroidClientMessage(topic, payload)
+	// 设备族适配器的纯文本状态主题
+	if adapter, vars, ok := h.deviceManager.MatchStatusTopic(t.Topics, topic); ok {
+		span.SetName("receive " + adapter.StatusTopic())
+		span.SetAttributes(attribute.String("device_id", vars[topics.VarDeviceID]), attribute.String("protocol", adapter.Name()))
+		h.handleAdapterStatus(t, adapter, vars, string(payload))
 		return
 	}
 
-	// 尝试解析为JSON消息
-	var mqttMsg types.MQTTMessage
-	if err := json.Unmarshal(payload, &mqttMsg); err != nil {
-		log.Printf("Failed to unmarshal message as JSON, treating as plain text: %v", err)
-		// 如果不是JSON，尝试作为简单字符串处理
+	message, ok := inboundMessage(t.Topics, topic)
+	if !ok {
+		logger.Debug("Unknown topic", "tenant_id", t.ID, "topic", topic)
 		h.handlePlainTextMessage(topic, string(payload))
 		return
 	}
 
-	// 根据主题处理消息
+	span.SetName("receive " + message)
+
+	// 按消息格式校验，不符合的消息记录后回复错误
+	mqttMsg, err := h.validator.Validate(message, payload)
+	if err != nil {
+		verr := schema.AsError(message, err)
+		if verr.DeviceID == "" {
+			verr.DeviceID = topicDeviceID(t.Topics, topic)
+		}
+		span.SetAttributes(attribute.String("device_id", verr.DeviceID))
+		span.SetStatus(codes.Error, verr.Error())
+		h.validator.Reject(t.ID, topic, payload, verr)
+		// 响应主题上也有服务端自己发布的消息，不回复以免循环
+		if message != schema.MessageResponse && verr.DeviceID != "" {
+			h.sendValidationError(t, verr)
+		}
+		return
+	}
+
+	deviceID := mqttMsg.DeviceID
+	if deviceID == "" {
+		deviceID = topicDeviceID(t.Topics, topic)
+	}
+	span.SetAttributes(attribute.String("device_id", deviceID))
+
+	// 根据消息类型处理
+	switch message {
+	case schema.MessageRegister:
+		h.handleDeviceRegister(ctx, t, mqttMsg)
+	case schema.MessageStatus:
+		h.handleDeviceStatus(t, mqttMsg)
+	case schema.MessageHeartbeat:
+		h.handleDeviceHeartbeat(t, mqttMsg)
+	case schema.MessageOffline:
+		h.handleDeviceOffline(t, mqttMsg)
+	case schema.MessageResponse:
+		h.handleDeviceResponse(ctx, t, mqttMsg, topic)
+	case schema.MessageConfigReported:
+		h.handleConfigReported(t, mqttMsg, topic)
+	case schema.MessageLogChunk:
+		h.handleLogChunk(t, mqttMsg, topic)
+	}
+}
+
+// 主题对应的入站消息类型
+func inboundMessage(tree *topics.Tree, topic string) (string, bool) {
 	switch {
-	case topic == types.TopicDeviceRegister:
-		h.handleDeviceRegister(&mqttMsg)
-	case topic == types.TopicDeviceStatus:
-		h.handleDeviceStatus(&mqttMsg)
-	case topic == types.TopicDeviceHeartbeat:
-		h.handleDeviceHeartbeat(&mqttMsg)
-	case topic == types.TopicDeviceOffline:
-		h.handleDeviceOffline(&mqttMsg)
-	case strings.HasPrefix(topic, types.TopicResponsePrefix):
-		h.handleDeviceResponse(&mqttMsg, topic)
-	default:
-		log.Printf("Unknown topic: %s", topic)
+	case matches(tree, topics.NameDeviceRegister, topic):
+		return schema.MessageRegister, true
+	case matches(tree, topics.NameDeviceStatus, topic):
+		return schema.MessageStatus, true
+	case matches(tree, topics.NameDeviceHeartbeat, topic):
+		return schema.MessageHeartbeat, true
+	case matches(tree, topics.NameDeviceOffline, topic):
+		return schema.MessageOffline, true
+	case matches(tree, topics.NameResponse, topic):
+		return schema.MessageResponse, true
+	case matches(tree, topics.NameConfigReported, topic):
+		return schema.MessageConfigReported, true
+	case matches(tree, topics.NameLogUpload, topic):
+		return schema.MessageLogChunk, true
+	}
+	return "", false
+}
+
+// 设备ID取自主题的消息（配置上报、日志上传），从主题中取出设备ID
+func topicDeviceID(tree *topics.Tree, topic string) string {
+	for _, name := range []string{topics.NameConfigReported, topics.NameLogUpload} {
+		if vars, ok := tree.Match(name, topic); ok {
+			return vars[topics.VarDeviceID]
+		}
 	}
+	return ""
 }
 
-// 处理设备注册
-func (h *Handler) handleDeviceRegister(msg *types.MQTTMessage) {
+// 在设备的响应主题上回复结构化的校验错误
+func (h *Handler) sendValidationError(t *tenant.Tenant, verr *schema.Error) {
+	data := map[string]interface{}{
+		"code":           verr.Code,
+		"message":        verr.Detail(),
+		"in_reply_to":    verr.Message,
+		"schema_version": verr.Version,
+	}
+	if len(verr.Errors) > 0 {
+		data["errors"] = verr.Errors
+	}
+	response := types.MQTTMessage{
+		Action:    "error",
+		Timestamp: time.Now().Unix(),
+		DeviceID:  verr.DeviceID,
+		Data:      data,
+	}
+
+	responseBytes, _ := json.Marshal(response)
+	if token := h.client.Publish(t.Topics.Response(verr.DeviceID), 1, false, responseBytes); token.Wait() && token.Error() != nil {
+		logger.Error("Failed to send validation error", "tenant_id", t.ID, "device_id", verr.DeviceID, "error", token.Error())
+	}
+}
+
+// 将消息的data解析为对应的结构（消息已通过格式校验）
+func decodeData(msg *types.MQTTMessage, v interface{}) error {
 	if msg.Data == nil {
-		log.Printf("Invalid register message: missing data")
-		return
+		return nil
+	}
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
 	}
+	return json.Unmarshal(data, v)
+}
 
-	deviceID, ok := msg.Data["device_id"].(string)
-	if !ok {
-		log.Printf("Invalid register message: missing device_id")
+// 处理设备注册
+func (h *Handler) handleDeviceRegister(ctx context.Context, t *tenant.Tenant, msg *types.MQTTMessage) {
+	var data types.RegisterData
+	if err := decodeData(msg, &data); err != nil {
+		logger.Warn("Failed to parse register data", "tenant_id", t.ID, "device_id", msg.DeviceID, "error", err)
 		return
 	}
 
-	clientID, _ := msg.Data["client_id"].(string)
-	if clientID == "" {
-		clientID = deviceID
+	deviceID := data.DeviceID
+	if data.ClientID == "" {
+		data.ClientID = deviceID
+	}
+	// 未上报设备信息时使用空map，便于之后合并覆盖项和按设备类型匹配
+	if data.DeviceInfo == nil {
+		data.DeviceInfo = make(map[string]string)
 	}
 
-	// 处理设备信息
-	deviceInfo := make(map[string]string)
-	if info, ok := msg.Data["device_info"].(map[string]interface{}); ok {
-		for k, v := range info {
-			if str, ok := v.(string); ok {
-				deviceInfo[k] = str
-			}
+	// 协议版本低于服务端支持的最低版本时拒绝注册
+	if data.ProtocolVersion != 0 && data.ProtocolVersion < types.MinProtocolVersion {
+		h.sendRegisterNack(t, deviceID, &device.AdmissionError{
+			Status: "rejected",
+			Reason: fmt.Sprintf("protocol version %d is not supported (minimum %d)", data.ProtocolVersion, types.MinProtocolVersion),
+		})
+		return
+	}
+
+	// 检查注册准入：预配置设备需在 data.token 中提供令牌
+	candidate := &types.PendingDevice{
+		DeviceID:   deviceID,
+		ClientID:   data.ClientID,
+		Protocol:   data.Protocol,
+		DeviceInfo: data.DeviceInfo,
+		Source:     "register",
+	}
+	if err := h.deviceManager.Admit(t.ID, candidate, data.Token, true); err != nil {
+		if admission, ok := err.(*device.AdmissionError); ok {
+			h.sendRegisterNack(t, deviceID, admission)
+		} else {
+			logger.Error("Failed to register device", "tenant_id", t.ID, "device_id", deviceID, "error", err)
 		}
+		return
 	}
 
 	// 注册设备
-	if err := h.deviceManager.RegisterDevice(deviceID, clientID, deviceInfo); err != nil {
-		log.Printf("Failed to register device: %v", err)
+	if err := h.deviceManager.RegisterDevice(t.ID, &data); err != nil {
+		logger.Warn("Failed to register device", "tenant_id", t.ID, "device_id", deviceID, "error", err)
 		return
 	}
 
-	// 发送注册确认
+	// 发送注册确认，附带协商后的协议版本和服务端支持的功能
 	response := types.MQTTMessage{
 		Action:    "register_ack",
 		Timestamp: time.Now().Unix(),
 		DeviceID:  deviceID,
 		Data: map[string]interface{}{
-			"status":  "success",
-			"message": "Device registered successfully",
+			"status":           "success",
+			"message":          "Device registered successfully",
+			"protocol_version": device.NegotiateVersion(data.ProtocolVersion),
+			"server":           h.serverInfo(),
 		},
 	}
 
+	// 启用命令签名时附带公钥，仅供设备发现密钥轮换；MQTT可被伪造，设备只信任预配置或HTTPS获取的公钥
+	if signer := h.deviceManager.Signer(); signer != nil && signer.Mode() != types.SigningOff {
+		if keys, err := signer.Keys(t.ID); err == nil {
+			response.Data["signing_keys"] = keys
+		} else {
+			logger.Error("Failed to load signing keys", "tenant_id", t.ID, "error", err)
+		}
+	}
+
 	responseBytes, _ := json.Marshal(response)
-	responseTopic := fmt.Sprintf("%s/%s", types.TopicResponsePrefix, deviceID)
+	responseTopic := t.Topics.Response(deviceID)
 	
 	if token := h.client.Publish(responseTopic, 1, false, responseBytes); token.Wait() && token.Error() != nil {
-		log.Printf("Failed to send register ACK: %v", token.Error())
+		logger.Error("Failed to send register ACK", "tenant_id", t.ID, "device_id", deviceID, "topic", responseTopic, "error", token.Error())
 	}
+
+	// 补发设备离线期间未应用的配置
+	h.deviceManager.ResendConfigDelta(ctx, t.ID, deviceID)
 }
 
-// 处理设备状态
-func (h *Handler) handleDeviceStatus(msg *types.MQTTMessage) {
-	if msg.Data == nil {
-		return
+// 服务端支持的协议版本和功能，随注册确认下发
+func (h *Handler) serverInfo() map[string]interface{} {
+	features := []string{"capabilities", "config_shadow", "enrollment", "ip_tracking", "restart_until_new_ip", "schema_validation", "telemetry"}
+	if signer := h.deviceManager.Signer(); signer != nil && signer.Mode() != types.SigningOff {
+		features = append(features, "command_signing")
+	}
+	if h.logCollector != nil {
+		features = append(features, "log_upload")
 	}
+	sort.Strings(features)
+	return map[string]interface{}{
+		"protocol_version":     types.ProtocolVersion,
+		"min_protocol_version": types.MinProtocolVersion,
+		"features":             features,
+	}
+}
 
+// 发送注册拒绝（rejected）或等待审批（pending）的通知
+func (h *Handler) sendRegisterNack(t *tenant.Tenant, deviceID string, admission *device.AdmissionError) {
+	response := types.MQTTMessage{
+		Action:    "register_nack",
+		Timestamp: time.Now().Unix(),
+		DeviceID:  deviceID,
+		Data: map[string]interface{}{
+			"status":  admission.Status,
+			"message": admission.Reason,
+			"server":  h.serverInfo(),
+		},
+	}
+
+	responseBytes, _ := json.Marshal(response)
+	if token := h.client.Publish(t.Topics.Response(deviceID), 1, false, responseBytes); token.Wait() && token.Error() != nil {
+		logger.Error("Failed to send register NACK", "tenant_id", t.ID, "device_id", deviceID, "error", token.Error())
+	}
+}
+
+// 处理设备状态
+func (h *Handler) handleDeviceStatus(t *tenant.Tenant, msg *types.MQTTMessage) {
 	var status types.ClientStatus
-	if data, err := json.Marshal(msg.Data); err == nil {
-		if err := json.Unmarshal(data, &status); err != nil {
-			log.Printf("Failed to parse status data: %v", err)
-			return
-		}
+	if err := decodeData(msg, &status); err != nil {
+		logger.Warn("Failed to parse status data", "tenant_id", t.ID, "device_id", msg.DeviceID, "error", err)
+		return
 	}
 
 	if status.DeviceID == "" && msg.DeviceID != "" {
 		status.DeviceID = msg.DeviceID
 	}
 
-	if err := h.deviceManager.UpdateDeviceStatus(status.DeviceID, &status); err != nil {
-		log.Printf("Failed to update device status: %v", err)
+	if err := h.deviceManager.UpdateDeviceStatus(t.ID, status.DeviceID, &status); err != nil {
+		logger.Warn("Failed to update device status", "tenant_id", t.ID, "device_id", status.DeviceID, "error", err)
 	}
 }
 
-// 处理设备心跳
-func (h *Handler) handleDeviceHeartbeat(msg *types.MQTTMessage) {
-	deviceID := msg.DeviceID
-	if deviceID == "" && msg.Data != nil {
-		if id, ok := msg.Data["device_id"].(string); ok {
-			deviceID = id
-		}
+// 处理设备心跳，data.telemetry 中可附带遥测数据
+func (h *Handler) handleDeviceHeartbeat(t *tenant.Tenant, msg *types.MQTTMessage) {
+	var data types.HeartbeatData
+	if err := decodeData(msg, &data); err != nil {
+		logger.Warn("Failed to parse heartbeat data", "tenant_id", t.ID, "device_id", msg.DeviceID, "error", err)
+		return
 	}
 
+	deviceID := msg.DeviceID
 	if deviceID == "" {
-		log.Printf("Invalid heartbeat message: missing device_id")
-		return
+		deviceID = data.DeviceID
 	}
 
-	if err := h.deviceManager.UpdateHeartbeat(deviceID); err != nil {
-		log.Printf("Failed to update heartbeat: %v", err)
+	if err := h.deviceManager.UpdateHeartbeat(t.ID, deviceID, data.Telemetry); err != nil {
+		logger.Warn("Failed to update heartbeat", "tenant_id", t.ID, "device_id", deviceID, "error", err)
 	}
 }
 
 // 处理设备离线
-func (h *Handler) handleDeviceOffline(msg *types.MQTTMessage) {
-	deviceID := msg.DeviceID
-	if deviceID == "" && msg.Data != nil {
-		if id, ok := msg.Data["device_id"].(string); ok {
-			deviceID = id
-		}
+func (h *Handler) handleDeviceOffline(t *tenant.Tenant, msg *types.MQTTMessage) {
+	var data types.OfflineData
+	if err := decodeData(msg, &data); err != nil {
+		logger.Warn("Failed to parse offline data", "tenant_id", t.ID, "device_id", msg.DeviceID, "error", err)
+		return
 	}
 
-	if deviceID != "" {
-		h.deviceManager.SetDeviceOffline(deviceID)
+	deviceID := msg.DeviceID
+	if deviceID == "" {
+		deviceID = data.DeviceID
 	}
+	h.deviceManager.SetDeviceOffline(t.ID, deviceID)
+}
+
+// 处理设备遗嘱（设备异常断开，broker代为发布）
+func (h *Handler) handleDeviceWill(t *tenant.Tenant, deviceID string) {
+	logger.Info("Received will message", "tenant_id", t.ID, "device_id", deviceID)
+	h.deviceManager.SetDeviceOffline(t.ID, deviceID)
 }
 
 // 处理设备响应
-func (h *Handler) handleDeviceResponse(msg *types.MQTTMessage, topic string) {
+func (h *Handler) handleDeviceResponse(ctx context.Context, t *tenant.Tenant, msg *types.MQTTMessage, topic string) {
 	// 从主题中提取设备ID
-	parts := strings.Split(topic, "/")
-	if len(parts) >= 3 {
-		deviceID := parts[2]
-		log.Printf("Received response from device %s: %s", deviceID, msg.Action)
+	if vars, ok := t.Topics.Match(topics.NameResponse, topic); ok {
+		deviceID := vars[topics.VarDeviceID]
+		logger.InfoContext(ctx, "Received response", "tenant_id", t.ID, "device_id", deviceID, "action", msg.Action)
+
+		// 设备无法收集日志时结束对应的日志包
+		if msg.Action == "logs_failed" && h.logCollector != nil {
+			var data struct {
+				BundleID string `json:"bundle_id"`
+				Error    string `json:"error"`
+			}
+			if err := decodeData(msg, &data); err == nil && data.BundleID != "" {
+				if err := h.logCollector.Fail(t.ID, deviceID, data.BundleID, data.Error); err != nil {
+					logger.Warn("Failed to update log bundle", "tenant_id", t.ID, "device_id", deviceID, "bundle_id", data.BundleID, "error", err)
+				}
+			}
+		}
 		
 		// 这里可以添加响应处理逻辑
 		// 比如记录命令执行结果，通知Web界面等
 	}
 }
 
+// 处理设备上报的配置，设备ID取自主题
+func (h *Handler) handleConfigReported(t *tenant.Tenant, msg *types.MQTTMessage, topic string) {
+	vars, _ := t.Topics.Match(topics.NameConfigReported, topic)
+
+	var report types.ConfigReportData
+	if err := decodeData(msg, &report); err != nil {
+		logger.Warn("Failed to parse reported config", "tenant_id", t.ID, "device_id", vars[topics.VarDeviceID], "error", err)
+		return
+	}
+
+	if err := h.deviceManager.ReportConfig(t.ID, vars[topics.VarDeviceID], &report); err != nil {
+		logger.Warn("Failed to update reported config", "tenant_id", t.ID, "device_id", vars[topics.VarDeviceID], "error", err)
+	}
+}
+
+// 处理设备上传的日志分片，设备ID取自主题
+func (h *Handler) handleLogChunk(t *tenant.Tenant, msg *types.MQTTMessage, topic string) {
+	if h.logCollector == nil {
+		logger.Warn("Log collection is not enabled, ignoring chunk", "tenant_id", t.ID, "topic", topic)
+		return
+	}
+	vars, _ := t.Topics.Match(topics.NameLogUpload, topic)
+
+	var chunk types.LogChunkData
+	if err := decodeData(msg, &chunk); err != nil {
+		logger.Warn("Failed to parse log chunk", "tenant_id", t.ID, "device_id", vars[topics.VarDeviceID], "error", err)
+		return
+	}
+
+	if err := h.logCollector.HandleChunk(t.ID, vars[topics.VarDeviceID], &chunk); err != nil {
+		logger.Warn("Failed to store log chunk", "tenant_id", t.ID, "device_id", vars[topics.VarDeviceID], "bundle_id", chunk.BundleID, "seq", chunk.Seq, "error", err)
+	}
+}
+
+// 设置设备日志收集器
+func (h *Handler) SetLogCollector(collector *logs.Collector) {
+	h.logCollector = collector
+}
+
 // 获取设备管理器
 func (h *Handler) GetDeviceManager() *device.Manager {
 	return h.deviceManager
 }
 
+// 获取入站消息校验器
+func (h *Handler) GetValidator() *schema.Validator {
+	return h.validator
+}
+
 // 获取MQTT客户端
 func (h *Handler) GetMQTTClient() mqtt.Client {
 	return h.client
@@ -279,84 +563,60 @@ func (h *Handler) GetMQTTClient() mqtt.Client {
 // 断开连接
 func (h *Handler) Disconnect() {
 	if h.client.IsConnected() {
+		// 主动断开不会触发遗嘱，需要自行发布离线状态
+		publishServerStatus(h.client, h.topics.ServerStatus(), h.config.ClientID, types.ServerStatusOffline)
 		h.client.Disconnect(1000)
-		log.Println("MQTT client disconnected")
+		logger.Info("MQTT client disconnected")
 	}
 }
 
-// 检查是否为Android客户端主题
-func (h *Handler) isAndroidClientTopic(topic string) bool {
-	// 检查是否匹配 device/{device_type}/restart4g 或 device/{device_type}/status
-	parts := strings.Split(topic, "/")
-	if len(parts) == 3 && parts[0] == "device" {
-		action := parts[2]
-		return action == "restart4g" || action == "status"
+// 构建服务端状态消息
+func serverStatusPayload(clientID, status string) []byte {
+	msg := types.MQTTMessage{
+		Action:    "server_status",
+		Timestamp: time.Now().Unix(),
+		Data: map[string]interface{}{
+			"client_id": clientID,
+			"status":    status,
+		},
 	}
-	return false
+
+	payload, _ := json.Marshal(msg)
+	return payload
 }
 
-// 处理Android客户端消息
-func (h *Handler) handleAndroidClientMessage(topic string, payload []byte) {
-	parts := strings.Split(topic, "/")
-	if len(parts) != 3 {
-		log.Printf("Invalid Android client topic format: %s", topic)
-		return
+// 发布服务端状态（retained）
+func publishServerStatus(client mqtt.Client, topic, clientID, status string) {
+	token := client.Publish(topic, 1, true, serverStatusPayload(clientID, status))
+	if token.Wait() && token.Error() != nil {
+		logger.Error("Failed to publish server status", "topic", topic, "status", status, "error", token.Error())
 	}
+}
 
-	deviceType := parts[1] // oppo, xiaomi, etc.
-	action := parts[2]     // restart4g, status
-	message := string(payload)
+// 获取主题树
+func (h *Handler) GetTopics() *topics.Tree {
+	return h.topics
+}
+
+// 检查主题是否匹配指定模板
+func matches(tree *topics.Tree, name, topic string) bool {
+	_, ok := tree.Match(name, topic)
+	return ok
+}
 
-	log.Printf("Android client message - DeviceType: %s, Action: %s, Message: %s", deviceType, action, message)
+// 处理设备族适配器状态主题上报的状态
+func (h *Handler) handleAdapterStatus(t *tenant.Tenant, adapter device.Adapter, vars map[string]string, message string) {
+	logger.Debug("Adapter status received", "tenant_id", t.ID, "device_id", vars[topics.VarDeviceID], "protocol", adapter.Name(), "message", message)
 
-	// 根据action类型处理
-	switch action {
-	case "restart4g":
-		// 这是从服务端发送给客户端的命令，不需要处理
-		log.Printf("Command topic received (should be published by server): %s", topic)
-	case "status":
-		// 处理设备状态报告
-		h.handleAndroidDeviceStatus(deviceType, message)
-	default:
-		log.Printf("Unknown Android client action: %s", action)
+	if err := h.deviceManager.ReportStatus(t.ID, adapter, vars, message); err != nil {
+		logger.Warn("Failed to update device status", "tenant_id", t.ID, "device_id", vars[topics.VarDeviceID], "protocol", adapter.Name(), "error", err)
 	}
 }
 
 // 处理简单文本消息
 func (h *Handler) handlePlainTextMessage(topic string, message string) {
-	log.Printf("Plain text message on topic %s: %s", topic, message)
+	logger.Debug("Plain text message", "topic", topic, "message", message)
 	
 	// 可以根据需要添加处理逻辑
 	// 比如如果是特定主题的简单命令
 }
-
-// 处理Android设备状态
-func (h *Handler) handleAndroidDeviceStatus(deviceType string, statusMessage string) {
-	// 生成设备ID（使用设备类型作为标识）
-	deviceID := fmt.Sprintf("%s-device", deviceType)
-	
-	// 尝试注册设备（如果不存在）
-	if _, err := h.deviceManager.GetDevice(deviceID); err != nil {
-		// 设备不存在，自动注册
-		deviceInfo := map[string]string{
-			"device_type": deviceType,
-			"platform":    "android",
-			"client_type": "android_mqtt",
-		}
-		
-		h.deviceManager.RegisterDevice(deviceID, fmt.Sprintf("%s-client", deviceType), deviceInfo)
-		log.Printf("Auto-registered Android device: %s", deviceID)
-	}
-	
-	// 更新设备状态
-	status := &types.ClientStatus{
-		DeviceID:      deviceID,
-		NetworkStatus: statusMessage,
-		Timestamp:     time.Now().Unix(),
-		LastAction:    "status_report",
-	}
-	
-	if err := h.deviceManager.UpdateDeviceStatus(deviceID, status); err != nil {
-		log.Printf("Failed to update Android device status: %v", err)
-	}
-}
\ No newline at end of file
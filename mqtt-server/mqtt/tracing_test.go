@@ -0,0 +1,118 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/device"
+	"mobile-admin-mqtt-server/schema"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/tracing"
+	"mobile-admin-mqtt-server/types"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// 已完成的token
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (doneToken) Error() error { return nil }
+
+// 记录发布消息的客户端
+type recordingClient struct {
+	mqtt.Client
+	published map[string][]byte
+}
+
+func (c *recordingClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.published[topic] = payload.([]byte)
+	return doneToken{}
+}
+
+// 收到的消息
+type message struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m message) Topic() string   { return m.topic }
+func (m message) Payload() []byte { return m.payload }
+
+// 命令载荷中的 traceparent 由设备在响应中原样返回，处理响应的span与下发命令的span属于同一追踪
+func TestResponseJoinsCommandTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	tracing.Install(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer tracing.Install(previous)
+
+	tenants, err := tenant.NewDefaultRegistry("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ten, _ := tenants.Get(tenant.DefaultID)
+	client := &recordingClient{published: make(map[string][]byte)}
+	h := &Handler{
+		client:        client,
+		deviceManager: device.NewManager(client, tenants),
+		tenants:       tenants,
+		validator:     schema.NewValidator(),
+	}
+	if err := h.deviceManager.RegisterDevice(ten.ID, &types.RegisterData{DeviceID: "phone-01"}); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, _ := command.NewDefaultRegistry().Get("ping")
+	if err := h.deviceManager.SendCommand(context.Background(), ten.ID, "phone-01", spec, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	var cmd struct {
+		TraceParent string `json:"traceparent"`
+	}
+	if err := json.Unmarshal(client.published[ten.Topics.Command("phone-01")], &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.TraceParent == "" {
+		t.Fatal("command payload has no traceparent")
+	}
+
+	response, _ := json.Marshal(map[string]interface{}{
+		"action":      "command_received",
+		"timestamp":   time.Now().Unix(),
+		"device_id":   "phone-01",
+		"traceparent": cmd.TraceParent,
+	})
+	h.messageHandler(client, message{topic: ten.Topics.Response("phone-01"), payload: response})
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	publish, ok := spans["publish command"]
+	if !ok {
+		t.Fatalf("no publish span in %v", exporter.GetSpans())
+	}
+	receive, ok := spans["receive "+schema.MessageResponse]
+	if !ok {
+		t.Fatalf("no receive span in %v", exporter.GetSpans())
+	}
+	if receive.SpanContext.TraceID() != publish.SpanContext.TraceID() {
+		t.Errorf("response trace %s, command trace %s", receive.SpanContext.TraceID(), publish.SpanContext.TraceID())
+	}
+	if receive.Parent.SpanID() != publish.SpanContext.SpanID() {
+		t.Errorf("response span parent %s, want command span %s", receive.Parent.SpanID(), publish.SpanContext.SpanID())
+	}
+}
@@ -0,0 +1,465 @@
+package rotation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mobile-admin-mqtt-server/command"
+	"mobile-admin-mqtt-server/device"
+	"mobile-admin-mqtt-server/logging"
+	"mobile-admin-mqtt-server/tenant"
+	"mobile-admin-mqtt-server/tracing"
+	"mobile-admin-mqtt-server/types"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	logger = logging.Logger("rotation")
+	tracer = tracing.Tracer("rotation")
+)
+
+// 组合命令名称
+const CommandName = "restart_until_new_ip"
+
+// 每个租户保留的已结束任务数
+const maxFinishedJobs = 100
+
+// 两次尝试之间的最长等待时间
+const maxBackoff = 10 * time.Minute
+
+// 换IP任务执行器：反复下发重启类命令，直到设备上报的IP可接受
+type Runner struct {
+	devices  *device.Manager
+	commands *command.Registry
+
+	jobs    map[string]*types.RotationJob
+	cancels map[string]context.CancelFunc
+	mutex   sync.RWMutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// 关闭中不再接受新任务（受mutex保护）
+	closing bool
+}
+
+// 创建换IP任务执行器
+func NewRunner(devices *device.Manager, commands *command.Registry) *Runner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{
+		devices:  devices,
+		commands: commands,
+		jobs:     make(map[string]*types.RotationJob),
+		cancels:  make(map[string]context.CancelFunc),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// 从组合命令的参数（已按命令定义校验并补全默认值）构建任务参数
+func OptionsFromParams(params map[string]interface{}) (types.RotationOptions, error) {
+	opts := types.RotationOptions{}
+	opts.Command, _ = params["restart_command"].(string)
+	if raw, ok := params["restart_params"].(string); ok && raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts.RestartParams); err != nil {
+			return opts, fmt.Errorf("restart_params must be a JSON object: %v", err)
+		}
+	}
+	opts.MaxAttempts, _ = params["max_attempts"].(int)
+	opts.BackoffSeconds, _ = params["backoff_seconds"].(int)
+	opts.BackoffMultiplier, _ = params["backoff_multiplier"].(float64)
+	if list, ok := params["blocklist"].(string); ok {
+		opts.Blocklist = splitList(list)
+	}
+	if list, ok := params["blocked_prefixes"].(string); ok {
+		opts.BlockedPrefixes = splitList(list)
+	}
+	return opts, nil
+}
+
+// 拆分逗号分隔的列表
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// 校验任务参数并补全默认值，返回每次尝试使用的重启命令
+func (r *Runner) validate(opts *types.RotationOptions) (*command.Spec, error) {
+	if opts.Command == "" {
+		opts.Command = "restart4g"
+	}
+	spec, ok := r.commands.Get(opts.Command)
+	if !ok {
+		return nil, fmt.Errorf("unknown restart command: %s", opts.Command)
+	}
+	if !spec.ChangesIP || spec.Composite {
+		return nil, fmt.Errorf("command %s cannot be used to change the IP", opts.Command)
+	}
+	params, err := spec.ValidateParams(opts.RestartParams)
+	if err != nil {
+		return nil, err
+	}
+	opts.RestartParams = params
+
+	if opts.MaxAttempts < 1 {
+		return nil, fmt.Errorf("max_attempts must be at least 1")
+	}
+	if opts.BackoffSeconds < 0 {
+		return nil, fmt.Errorf("backoff_seconds must not be negative")
+	}
+	if opts.BackoffMultiplier < 1 {
+		opts.BackoffMultiplier = 1
+	}
+
+	for _, prefix := range opts.BlockedPrefixes {
+		if strings.Contains(prefix, "/") {
+			if _, _, err := net.ParseCIDR(prefix); err != nil {
+				return nil, fmt.Errorf("invalid blocked prefix %s: %v", prefix, err)
+			}
+		}
+	}
+	return spec, nil
+}
+
+// 启动换IP任务，同一设备同时只能有一个任务在执行；任务的span挂在ctx中的span（发起请求）之下
+func (r *Runner) Start(ctx context.Context, t *tenant.Tenant, deviceID string, opts types.RotationOptions) (*types.RotationJob, error) {
+	spec, err := r.validate(&opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := r.devices.IPReport(t.ID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	// 设备声明了能力时，必须支持用于换IP的重启命令
+	if err := r.devices.CheckCapability(t.ID, deviceID, spec.Name); err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closing {
+		return nil, fmt.Errorf("server is shutting down")
+	}
+	for _, job := range r.jobs {
+		if job.TenantID == t.ID && job.DeviceID == deviceID && job.Status == types.RotationRunning {
+			return nil, fmt.Errorf("rotation job %s is already running for device %s", job.ID, deviceID)
+		}
+	}
+
+	job := &types.RotationJob{
+		ID:         uuid.New().String(),
+		TenantID:   t.ID,
+		DeviceID:   deviceID,
+		Options:    opts,
+		Status:     types.RotationRunning,
+		OriginalIP: report.CurrentIP,
+		Attempts:   []types.RotationAttempt{},
+		CreatedAt:  time.Now(),
+	}
+	jobCtx, cancel := context.WithCancel(r.ctx)
+	r.jobs[job.ID] = job
+	r.cancels[job.ID] = cancel
+
+	// 任务比请求存活得久：只继承请求的span，不继承其取消
+	jobCtx, span := tracer.Start(trace.ContextWithSpan(jobCtx, trace.SpanFromContext(ctx)), "rotation job",
+		trace.WithAttributes(
+			attribute.String("job_id", job.ID),
+			attribute.String("tenant_id", t.ID),
+			attribute.String("device_id", deviceID),
+		))
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.run(jobCtx, t, job, spec)
+		span.SetAttributes(attribute.String("status", job.Status), attribute.Int("attempts", len(job.Attempts)))
+		span.End()
+	}()
+
+	logger.InfoContext(jobCtx, "Rotation job started", "job_id", job.ID, "tenant_id", t.ID, "device_id", deviceID,
+		"original_ip", job.OriginalIP, "max_attempts", opts.MaxAttempts)
+	return copyJob(job), nil
+}
+
+// 执行任务
+func (r *Runner) run(ctx context.Context, t *tenant.Tenant, job *types.RotationJob, spec *command.Spec) {
+	opts := job.Options
+
+	for attempt := 1; attempt <= opts.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			if !sleep(ctx, backoff(opts, attempt)) {
+				r.finish(job, types.RotationCancelled, "")
+				return
+			}
+		}
+
+		current := job.OriginalIP
+		if report, err := r.devices.IPReport(t.ID, job.DeviceID); err == nil {
+			current = report.CurrentIP
+		}
+		r.update(job, func() {
+			job.Attempts = append(job.Attempts, types.RotationAttempt{
+				Attempt:   attempt,
+				StartedAt: time.Now(),
+				Status:    types.RestartPending,
+				IPBefore:  current,
+			})
+		})
+
+		if !t.AllowCommand() {
+			r.endAttempt(job, "rate_limited", "", false, "tenant command quota exceeded")
+			continue
+		}
+		if err := r.devices.SendCommand(ctx, t.ID, job.DeviceID, spec, opts.RestartParams, ""); err != nil {
+			r.endAttempt(job, "send_failed", "", false, err.Error())
+			continue
+		}
+
+		result, err := r.devices.WaitRestart(ctx, t.ID, job.DeviceID)
+		if err != nil {
+			if ctx.Err() != nil {
+				r.endAttempt(job, types.RotationCancelled, "", false, "job cancelled")
+				r.finish(job, types.RotationCancelled, "")
+				return
+			}
+			r.endAttempt(job, "wait_failed", "", false, err.Error())
+			continue
+		}
+
+		if result.Status != types.RestartSucceeded {
+			r.endAttempt(job, result.Status, result.IPAfter, false, fmt.Sprintf("%s %s", spec.Name, result.Status))
+			continue
+		}
+
+		accepted, reason := accept(opts, job.OriginalIP, result.IPAfter)
+		r.endAttempt(job, result.Status, result.IPAfter, accepted, reason)
+		if accepted {
+			r.finish(job, types.RotationSucceeded, result.IPAfter)
+			return
+		}
+	}
+
+	r.finish(job, types.RotationFailed, "")
+}
+
+// 第attempt次尝试前的等待时间
+func backoff(opts types.RotationOptions, attempt int) time.Duration {
+	seconds := float64(opts.BackoffSeconds) * math.Pow(opts.BackoffMultiplier, float64(attempt-2))
+	wait := time.Duration(seconds * float64(time.Second))
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// 等待指定时间，任务被取消时返回false
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 判断重启后的IP是否可接受：与任务开始前不同，且不在黑名单和屏蔽前缀中
+func accept(opts types.RotationOptions, original, ip string) (bool, string) {
+	if ip == "" {
+		return false, "device did not report an IP"
+	}
+	if ip == original {
+		return false, "same ip as before"
+	}
+	for _, blocked := range opts.Blocklist {
+		if ip == blocked {
+			return false, fmt.Sprintf("ip %s is in blocklist", ip)
+		}
+	}
+	for _, prefix := range opts.BlockedPrefixes {
+		if matchPrefix(prefix, ip) {
+			return false, fmt.Sprintf("ip %s matches blocked prefix %s", ip, prefix)
+		}
+	}
+	return true, "new ip"
+}
+
+// 匹配IP前缀，支持CIDR和字符串前缀
+func matchPrefix(prefix, ip string) bool {
+	if strings.Contains(prefix, "/") {
+		_, network, err := net.ParseCIDR(prefix)
+		return err == nil && network.Contains(net.ParseIP(ip))
+	}
+	return strings.HasPrefix(ip, prefix)
+}
+
+// 在锁内修改任务
+func (r *Runner) update(job *types.RotationJob, fn func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	fn()
+}
+
+// 结束当前尝试并记录结果
+func (r *Runner) endAttempt(job *types.RotationJob, status, ip string, accepted bool, reason string) {
+	var attempt int
+	r.update(job, func() {
+		a := &job.Attempts[len(job.Attempts)-1]
+		attempt = a.Attempt
+		now := time.Now()
+		a.FinishedAt = &now
+		a.Status = status
+		a.IPAfter = ip
+		a.Accepted = accepted
+		a.Reason = reason
+	})
+	logger.Info("Rotation attempt finished", "job_id", job.ID, "tenant_id", job.TenantID, "device_id", job.DeviceID,
+		"attempt", attempt, "status", status, "ip", ip, "accepted", accepted, "reason", reason)
+}
+
+// 结束任务，并清理该租户过多的已结束任务
+func (r *Runner) finish(job *types.RotationJob, status, finalIP string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	job.Status = status
+	job.FinalIP = finalIP
+	job.FinishedAt = &now
+	if cancel, ok := r.cancels[job.ID]; ok {
+		cancel()
+		delete(r.cancels, job.ID)
+	}
+	logger.Info("Rotation job finished", "job_id", job.ID, "tenant_id", job.TenantID, "device_id", job.DeviceID,
+		"status", status, "attempts", len(job.Attempts), "final_ip", finalIP)
+
+	var finished []*types.RotationJob
+	for _, j := range r.jobs {
+		if j.TenantID == job.TenantID && j.FinishedAt != nil {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) > maxFinishedJobs {
+		sort.Slice(finished, func(i, j int) bool {
+			return finished[i].FinishedAt.Before(*finished[j].FinishedAt)
+		})
+		for _, j := range finished[:len(finished)-maxFinishedJobs] {
+			delete(r.jobs, j.ID)
+		}
+	}
+}
+
+// 获取任务（副本）
+func (r *Runner) Get(tenantID, id string) (*types.RotationJob, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	job, ok := r.jobs[id]
+	if !ok || job.TenantID != tenantID {
+		return nil, false
+	}
+	return copyJob(job), true
+}
+
+// 获取租户的任务（副本，最新的在前），deviceID非空时只返回该设备的任务
+func (r *Runner) List(tenantID, deviceID string) []*types.RotationJob {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	jobs := make([]*types.RotationJob, 0)
+	for _, job := range r.jobs {
+		if job.TenantID == tenantID && (deviceID == "" || job.DeviceID == deviceID) {
+			jobs = append(jobs, copyJob(job))
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// 取消正在执行的任务
+func (r *Runner) Cancel(tenantID, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok || job.TenantID != tenantID {
+		return fmt.Errorf("rotation job not found: %s", id)
+	}
+	cancel, ok := r.cancels[id]
+	if !ok {
+		return fmt.Errorf("rotation job %s is already %s", id, job.Status)
+	}
+	cancel()
+	return nil
+}
+
+// 取消设备正在执行的任务（如设备被删除时）
+func (r *Runner) CancelDevice(tenantID, deviceID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for id, cancel := range r.cancels {
+		if job := r.jobs[id]; job.TenantID == tenantID && job.DeviceID == deviceID {
+			cancel()
+		}
+	}
+}
+
+// 停止接受新任务并等待运行中的任务结束；ctx 到期时取消剩余任务（记为 cancelled）并等待执行协程退出
+func (r *Runner) Shutdown(ctx context.Context) {
+	r.mutex.Lock()
+	r.closing = true
+	running := len(r.cancels)
+	r.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	if running > 0 {
+		logger.Info("Waiting for rotation jobs to finish", "running", running)
+	}
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	r.mutex.RLock()
+	running = len(r.cancels)
+	r.mutex.RUnlock()
+	logger.Warn("Shutdown deadline reached, cancelling rotation jobs", "running", running)
+	r.cancel()
+	<-done
+}
+
+// 复制任务，避免调用方与执行协程并发访问
+func copyJob(job *types.RotationJob) *types.RotationJob {
+	c := *job
+	c.Attempts = make([]types.RotationAttempt, len(job.Attempts))
+	copy(c.Attempts, job.Attempts)
+	return &c
+}
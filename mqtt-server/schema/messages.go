@@ -0,0 +1,179 @@
+package schema
+
+import "regexp"
+
+// 入站消息名称
+const (
+	MessageRegister  = "register"
+	MessageStatus    = "status"
+	MessageHeartbeat = "heartbeat"
+	MessageOffline   = "offline"
+	MessageResponse  = "response"
+	// 设备上报的配置
+	MessageConfigReported = "config_reported"
+	// 设备上传的日志分片
+	MessageLogChunk = "log_chunk"
+)
+
+// 未声明 schema_version 的消息按此版本校验
+const DefaultVersion = 1
+
+// 设备ID的长度限制
+const maxDeviceIDLength = 128
+
+// 日志分片解码后的最大长度，base64编码后需在消息长度限制之内
+const MaxLogChunkSize = 32 * 1024
+
+// 设备ID会用于拼接主题，不能包含主题分隔符和通配符
+var deviceIDPattern = regexp.MustCompile(`^[^/+#]+$`)
+
+// 所有消息共有的顶层字段
+func envelope(action *Field, data *Field) []*Field {
+	return []*Field{
+		action,
+		{Name: "timestamp", Type: TypeInteger, Description: "发送时间（Unix秒）"},
+		deviceIDField(false),
+		{Name: "schema_version", Type: TypeInteger, Minimum: number(1), Description: "消息格式版本，默认1"},
+		{Name: "traceparent", Type: TypeString, MaxLength: 128, Description: "W3C追踪上下文，响应命令时原样返回命令中的值"},
+		{Name: "tracestate", Type: TypeString, MaxLength: 512},
+		data,
+	}
+}
+
+func optionalAction() *Field {
+	return &Field{Name: "action", Type: TypeString, MaxLength: 64}
+}
+
+func deviceIDField(required bool) *Field {
+	return &Field{Name: "device_id", Type: TypeString, Required: required, MinLength: 1, MaxLength: maxDeviceIDLength, Pattern: deviceIDPattern}
+}
+
+// 遥测数据，与 types.Telemetry 对应
+func telemetryField() *Field {
+	return &Field{
+		Name: "telemetry",
+		Type: TypeObject,
+		Fields: []*Field{
+			{Name: "timestamp", Type: TypeInteger},
+			{Name: "rsrp", Type: TypeNumber, Minimum: number(-160), Maximum: number(0), Description: "dBm"},
+			{Name: "rsrq", Type: TypeNumber, Minimum: number(-40), Maximum: number(10), Description: "dB"},
+			{Name: "sinr", Type: TypeNumber, Minimum: number(-30), Maximum: number(60), Description: "dB"},
+			{Name: "carrier", Type: TypeString, MaxLength: 64},
+			{Name: "cell_id", Type: TypeString, MaxLength: 64},
+			{Name: "network_type", Type: TypeString, MaxLength: 16},
+			{Name: "ip_address", Type: TypeString, MaxLength: 64},
+			{Name: "public_ip", Type: TypeString, MaxLength: 64},
+			{Name: "battery", Type: TypeInteger, Minimum: number(0), Maximum: number(100)},
+			{Name: "charging", Type: TypeBoolean},
+			{Name: "data_rx_bytes", Type: TypeInteger, Minimum: number(0)},
+			{Name: "data_tx_bytes", Type: TypeInteger, Minimum: number(0)},
+		},
+	}
+}
+
+// 内置的消息格式，按名称和版本索引
+var builtinSchemas = []*Schema{
+	{
+		Name:        MessageRegister,
+		Version:     1,
+		Description: "设备注册，发布到 device/register",
+		Fields: envelope(optionalAction(), &Field{
+			Name:     "data",
+			Type:     TypeObject,
+			Required: true,
+			Fields: []*Field{
+				deviceIDField(true),
+				{Name: "client_id", Type: TypeString, MaxLength: 128},
+				{Name: "protocol", Type: TypeString, MaxLength: 32, Description: "命令编码方式，默认json"},
+				{Name: "token", Type: TypeString, MaxLength: 256, Description: "预配置设备的令牌"},
+				{Name: "device_info", Type: TypeObject, Values: &Field{Type: TypeString, MaxLength: 256}},
+				{Name: "protocol_version", Type: TypeInteger, Minimum: number(1), Description: "设备支持的协议版本，默认1"},
+				{Name: "capabilities", Type: TypeArray, MaxItems: 64, Items: &Field{Type: TypeString, MinLength: 1, MaxLength: 64},
+					Description: "设备能执行的命令，声明后服务端拒绝发送未声明的命令"},
+			},
+		}),
+	},
+	{
+		Name:        MessageStatus,
+		Version:     1,
+		Description: "设备网络状态，发布到 device/status",
+		Fields: envelope(optionalAction(), &Field{
+			Name:     "data",
+			Type:     TypeObject,
+			Required: true,
+			Fields: []*Field{
+				deviceIDField(false),
+				{Name: "network_status", Type: TypeString, Required: true, MinLength: 1, MaxLength: 64},
+				{Name: "timestamp", Type: TypeInteger},
+				{Name: "last_action", Type: TypeString, MaxLength: 64},
+				{Name: "public_ip", Type: TypeString, MaxLength: 64},
+				telemetryField(),
+			},
+		}),
+		AnyOf: []string{"device_id", "data.device_id"},
+	},
+	{
+		Name:        MessageHeartbeat,
+		Version:     1,
+		Description: "设备心跳，发布到 device/heartbeat",
+		Fields: envelope(optionalAction(), &Field{
+			Name: "data",
+			Type: TypeObject,
+			Fields: []*Field{
+				deviceIDField(false),
+				telemetryField(),
+			},
+		}),
+		AnyOf: []string{"device_id", "data.device_id"},
+	},
+	{
+		Name:        MessageOffline,
+		Version:     1,
+		Description: "设备主动下线，发布到 device/offline",
+		Fields: envelope(optionalAction(), &Field{
+			Name:   "data",
+			Type:   TypeObject,
+			Fields: []*Field{deviceIDField(false)},
+		}),
+		AnyOf: []string{"device_id", "data.device_id"},
+	},
+	{
+		Name:        MessageResponse,
+		Version:     1,
+		Description: "设备命令响应，发布到 device/response/{device_id}，设备ID取自主题",
+		Fields: envelope(
+			&Field{Name: "action", Type: TypeString, Required: true, MinLength: 1, MaxLength: 64},
+			&Field{Name: "data", Type: TypeObject},
+		),
+	},
+	{
+		Name:        MessageConfigReported,
+		Version:     1,
+		Description: "设备上报的配置，发布到 device/config/{device_id}/reported，设备ID取自主题",
+		Fields: envelope(optionalAction(), &Field{
+			Name:     "data",
+			Type:     TypeObject,
+			Required: true,
+			Fields: []*Field{
+				{Name: "version", Type: TypeInteger, Minimum: number(0), Description: "设备已应用的期望配置版本"},
+				{Name: "reported", Type: TypeObject, Required: true, Description: "设备当前的配置，值为null表示删除该项"},
+			},
+		}),
+	},
+	{
+		Name:        MessageLogChunk,
+		Version:     1,
+		Description: "设备上传的日志分片，发布到 device/logs/{device_id}，设备ID取自主题",
+		Fields: envelope(optionalAction(), &Field{
+			Name:     "data",
+			Type:     TypeObject,
+			Required: true,
+			Fields: []*Field{
+				{Name: "bundle_id", Type: TypeString, Required: true, MinLength: 1, MaxLength: 64, Description: "collect_logs 命令中的日志包ID"},
+				{Name: "seq", Type: TypeInteger, Required: true, Minimum: number(0), Description: "分片序号，从0开始"},
+				{Name: "total", Type: TypeInteger, Required: true, Minimum: number(1), Description: "分片总数"},
+				{Name: "data", Type: TypeString, Required: true, MaxLength: (MaxLogChunkSize + 2) / 3 * 4, Description: "base64编码的日志内容"},
+			},
+		}),
+	},
+}
@@ -0,0 +1,299 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// 字段类型
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeObject  = "object"
+	TypeArray   = "array"
+)
+
+// 消息字段定义
+type Field struct {
+	Name        string
+	Type        string
+	Required    bool
+	Description string
+	// 字符串的枚举值、长度和格式
+	Enum      []string
+	MinLength int
+	MaxLength int
+	Pattern   *regexp.Regexp
+	// 数值范围
+	Minimum *float64
+	Maximum *float64
+	// 对象的已知字段；Values 不为空时表示对象的每个值都必须符合该定义
+	Fields []*Field
+	Values *Field
+	// 数组元素的定义和最大长度
+	Items    *Field
+	MaxItems int
+}
+
+// 某类消息某个版本的格式定义。未列出的字段允许出现，便于客户端向前兼容
+type Schema struct {
+	Name        string
+	Version     int
+	Description string
+	Fields      []*Field
+	// 至少需要提供其中一个字段（路径，如 device_id、data.device_id）
+	AnyOf []string
+}
+
+func number(v float64) *float64 {
+	return &v
+}
+
+// 校验已解析的消息（json.Decoder.UseNumber 解码），返回所有不符合的字段
+func (s *Schema) validate(msg map[string]interface{}) []types.FieldError {
+	var errs []types.FieldError
+	validateFields("", s.Fields, msg, &errs)
+
+	if len(s.AnyOf) > 0 {
+		found := false
+		for _, path := range s.AnyOf {
+			if v, ok := lookup(msg, path); ok && v != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, types.FieldError{
+				Field:   s.AnyOf[0],
+				Message: fmt.Sprintf("is required (or %s)", strings.Join(s.AnyOf[1:], ", ")),
+			})
+		}
+	}
+	return errs
+}
+
+func validateFields(prefix string, fields []*Field, obj map[string]interface{}, errs *[]types.FieldError) {
+	for _, f := range fields {
+		path := prefix + f.Name
+		value, ok := obj[f.Name]
+		if !ok || value == nil {
+			if f.Required {
+				*errs = append(*errs, types.FieldError{Field: path, Message: "is required"})
+			}
+			continue
+		}
+		validateValue(path, f, value, errs)
+	}
+}
+
+func validateValue(path string, f *Field, value interface{}, errs *[]types.FieldError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, types.FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch f.Type {
+	case TypeString:
+		s, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if len(s) < f.MinLength {
+			fail("must be at least %d characters", f.MinLength)
+		}
+		if f.MaxLength > 0 && len(s) > f.MaxLength {
+			fail("must be at most %d characters", f.MaxLength)
+		}
+		if len(f.Enum) > 0 && !contains(f.Enum, s) {
+			fail("must be one of %s", strings.Join(f.Enum, ", "))
+		}
+		if f.Pattern != nil && !f.Pattern.MatchString(s) {
+			fail("must match %s", f.Pattern)
+		}
+
+	case TypeInteger, TypeNumber:
+		n, ok := value.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		v, err := n.Float64()
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if f.Type == TypeInteger {
+			if _, err := n.Int64(); err != nil {
+				fail("must be an integer")
+				return
+			}
+		}
+		if f.Minimum != nil && v < *f.Minimum {
+			fail("must be >= %g", *f.Minimum)
+		}
+		if f.Maximum != nil && v > *f.Maximum {
+			fail("must be <= %g", *f.Maximum)
+		}
+
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+		}
+
+	case TypeArray:
+		items, ok := value.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		if f.MaxItems > 0 && len(items) > f.MaxItems {
+			fail("must have at most %d items", f.MaxItems)
+			return
+		}
+		if f.Items != nil {
+			for i, item := range items {
+				validateValue(fmt.Sprintf("%s[%d]", path, i), f.Items, item, errs)
+			}
+		}
+
+	case TypeObject:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		validateFields(path+".", f.Fields, obj, errs)
+		if f.Values != nil {
+			keys := make([]string, 0, len(obj))
+			for key := range obj {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if obj[key] != nil {
+					validateValue(path+"."+key, f.Values, obj[key], errs)
+				}
+			}
+		}
+	}
+}
+
+// 按路径（以 . 分隔）取字符串字段
+func lookup(msg map[string]interface{}, path string) (string, bool) {
+	var current interface{} = msg
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if current, ok = obj[part]; !ok {
+			return "", false
+		}
+	}
+	s, ok := current.(string)
+	return s, ok
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// 导出为 JSON Schema（draft 2020-12）文档
+func (s *Schema) JSONSchema() map[string]interface{} {
+	doc := objectSchema(s.Fields, nil)
+	doc["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	doc["$id"] = fmt.Sprintf("m4g:%s:v%d", s.Name, s.Version)
+	doc["title"] = fmt.Sprintf("%s v%d", s.Name, s.Version)
+	doc["description"] = s.Description
+	if len(s.AnyOf) > 0 {
+		anyOf := make([]interface{}, 0, len(s.AnyOf))
+		for _, path := range s.AnyOf {
+			anyOf = append(anyOf, requirePath(strings.Split(path, ".")))
+		}
+		doc["anyOf"] = anyOf
+	}
+	return doc
+}
+
+func objectSchema(fields []*Field, values *Field) map[string]interface{} {
+	doc := map[string]interface{}{"type": TypeObject}
+	if len(fields) > 0 {
+		properties := make(map[string]interface{}, len(fields))
+		var required []string
+		for _, f := range fields {
+			properties[f.Name] = fieldSchema(f)
+			if f.Required {
+				required = append(required, f.Name)
+			}
+		}
+		doc["properties"] = properties
+		if len(required) > 0 {
+			doc["required"] = required
+		}
+	}
+	if values != nil {
+		doc["additionalProperties"] = fieldSchema(values)
+	}
+	return doc
+}
+
+func fieldSchema(f *Field) map[string]interface{} {
+	var doc map[string]interface{}
+	switch f.Type {
+	case TypeObject:
+		doc = objectSchema(f.Fields, f.Values)
+	case TypeArray:
+		doc = map[string]interface{}{"type": f.Type}
+		if f.Items != nil {
+			doc["items"] = fieldSchema(f.Items)
+		}
+		if f.MaxItems > 0 {
+			doc["maxItems"] = f.MaxItems
+		}
+	default:
+		doc = map[string]interface{}{"type": f.Type}
+	}
+	if f.Description != "" {
+		doc["description"] = f.Description
+	}
+	if len(f.Enum) > 0 {
+		doc["enum"] = f.Enum
+	}
+	if f.MinLength > 0 {
+		doc["minLength"] = f.MinLength
+	}
+	if f.MaxLength > 0 {
+		doc["maxLength"] = f.MaxLength
+	}
+	if f.Pattern != nil {
+		doc["pattern"] = f.Pattern.String()
+	}
+	if f.Minimum != nil {
+		doc["minimum"] = *f.Minimum
+	}
+	if f.Maximum != nil {
+		doc["maximum"] = *f.Maximum
+	}
+	return doc
+}
+
+// 要求嵌套路径存在的子schema，如 data.device_id
+func requirePath(parts []string) map[string]interface{} {
+	doc := map[string]interface{}{"required": []string{parts[0]}}
+	if len(parts) > 1 {
+		doc["properties"] = map[string]interface{}{parts[0]: requirePath(parts[1:])}
+	}
+	return doc
+}
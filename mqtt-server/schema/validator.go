@@ -0,0 +1,242 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mobile-admin-mqtt-server/logging"
+	"mobile-admin-mqtt-server/types"
+)
+
+var logger = logging.Logger("schema")
+
+// 校验失败的错误码
+const (
+	CodeInvalidJSON        = "invalid_json"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeSchemaViolation    = "schema_violation"
+	// 其他原因的拒绝（非校验器产生的错误）
+	CodeInvalidMessage = "invalid_message"
+)
+
+const (
+	// 入站消息的最大长度
+	maxPayloadSize = 64 * 1024
+	// 每类消息保留的最近拒绝样本数
+	maxSamples = 20
+	// 样本中保留的载荷长度
+	maxSampleBytes = 1024
+)
+
+// 消息校验失败
+type Error struct {
+	Code    string
+	Message string
+	Version int
+	Errors  []types.FieldError
+	// 从载荷中尽量取出的设备ID，用于回复错误；无法确定时为空
+	DeviceID string
+	detail   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s message rejected (%s): %s", e.Message, e.Code, e.Detail())
+}
+
+// 错误说明，用于日志和回复设备
+func (e *Error) Detail() string {
+	if e.detail != "" {
+		return e.detail
+	}
+	parts := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		parts = append(parts, fe.Field+" "+fe.Message)
+	}
+	return fmt.Sprintf("does not match %s schema v%d: %s", e.Message, e.Version, strings.Join(parts, "; "))
+}
+
+// 把校验错误转换为 *Error，其他类型的错误包装为 invalid_message
+func AsError(message string, err error) *Error {
+	var verr *Error
+	if errors.As(err, &verr) {
+		return verr
+	}
+	return &Error{Code: CodeInvalidMessage, Message: message, Version: DefaultVersion, detail: err.Error()}
+}
+
+// 入站消息校验器，按租户和消息类型统计被拒绝的消息
+type Validator struct {
+	schemas map[string]map[int]*Schema
+
+	rejects map[string]map[string]*types.RejectStats
+	mutex   sync.Mutex
+}
+
+// 创建使用内置消息格式的校验器
+func NewValidator() *Validator {
+	v := &Validator{
+		schemas: make(map[string]map[int]*Schema),
+		rejects: make(map[string]map[string]*types.RejectStats),
+	}
+	for _, s := range builtinSchemas {
+		if v.schemas[s.Name] == nil {
+			v.schemas[s.Name] = make(map[int]*Schema)
+		}
+		v.schemas[s.Name][s.Version] = s
+	}
+	return v
+}
+
+// 所有消息格式，按名称和版本排序
+func (v *Validator) Schemas() []*Schema {
+	result := make([]*Schema, 0)
+	for _, versions := range v.schemas {
+		for _, s := range versions {
+			result = append(result, s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Version < result[j].Version
+	})
+	return result
+}
+
+// 按消息声明的 schema_version（默认1）校验并解析消息，失败时返回 *Error
+func (v *Validator) Validate(message string, payload []byte) (*types.MQTTMessage, error) {
+	if len(payload) > maxPayloadSize {
+		return nil, &Error{Code: CodePayloadTooLarge, Message: message, Version: DefaultVersion,
+			detail: fmt.Sprintf("payload is %d bytes, limit is %d", len(payload), maxPayloadSize)}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, &Error{Code: CodeInvalidJSON, Message: message, Version: DefaultVersion, detail: err.Error()}
+	}
+	if decoder.More() {
+		return nil, &Error{Code: CodeInvalidJSON, Message: message, Version: DefaultVersion, detail: "unexpected data after JSON object"}
+	}
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, &Error{Code: CodeInvalidJSON, Message: message, Version: DefaultVersion, detail: "payload must be a JSON object"}
+	}
+
+	deviceID, ok := lookup(obj, "device_id")
+	if !ok || deviceID == "" {
+		deviceID, _ = lookup(obj, "data.device_id")
+	}
+	if len(deviceID) > maxDeviceIDLength || !deviceIDPattern.MatchString(deviceID) {
+		deviceID = ""
+	}
+
+	version := DefaultVersion
+	if n, ok := obj["schema_version"].(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			version = int(i)
+		}
+	}
+	s, ok := v.schemas[message][version]
+	if !ok {
+		return nil, &Error{Code: CodeUnsupportedVersion, Message: message, Version: version, DeviceID: deviceID,
+			detail: fmt.Sprintf("unsupported schema_version %d for %s messages", version, message)}
+	}
+
+	if errs := s.validate(obj); len(errs) > 0 {
+		return nil, &Error{Code: CodeSchemaViolation, Message: message, Version: version, Errors: errs, DeviceID: deviceID}
+	}
+
+	var msg types.MQTTMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, &Error{Code: CodeInvalidJSON, Message: message, Version: version, DeviceID: deviceID, detail: err.Error()}
+	}
+	return &msg, nil
+}
+
+// 记录被拒绝的消息，保留最近的样本（载荷截断）
+func (v *Validator) Reject(tenantID, topic string, payload []byte, verr *Error) {
+	logger.Warn("Rejected message", "tenant_id", tenantID, "device_id", verr.DeviceID, "topic", topic, "code", verr.Code, "error", verr.Error())
+
+	payload = redact(payload)
+	if len(payload) > maxSampleBytes {
+		payload = payload[:maxSampleBytes]
+	}
+	now := time.Now()
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	partition, ok := v.rejects[tenantID]
+	if !ok {
+		partition = make(map[string]*types.RejectStats)
+		v.rejects[tenantID] = partition
+	}
+	stats, ok := partition[verr.Message]
+	if !ok {
+		stats = &types.RejectStats{Message: verr.Message}
+		partition[verr.Message] = stats
+	}
+	stats.Count++
+	stats.LastAt = now
+	stats.Samples = append(stats.Samples, types.RejectSample{
+		Topic:   topic,
+		Code:    verr.Code,
+		Errors:  verr.Errors,
+		Payload: string(payload),
+		At:      now,
+	})
+	if len(stats.Samples) > maxSamples {
+		stats.Samples = stats.Samples[len(stats.Samples)-maxSamples:]
+	}
+}
+
+// 租户的拒绝统计（副本，按消息类型排序，样本最新的在前）
+func (v *Validator) Rejects(tenantID string) []*types.RejectStats {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	result := make([]*types.RejectStats, 0, len(v.rejects[tenantID]))
+	for _, stats := range v.rejects[tenantID] {
+		c := *stats
+		c.Samples = make([]types.RejectSample, 0, len(stats.Samples))
+		for i := len(stats.Samples) - 1; i >= 0; i-- {
+			c.Samples = append(c.Samples, stats.Samples[i])
+		}
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Message < result[j].Message
+	})
+	return result
+}
+
+// 去掉样本中的设备令牌，无法解析的载荷原样返回
+func redact(payload []byte) []byte {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(payload, &obj); err != nil {
+		return payload
+	}
+	data, ok := obj["data"].(map[string]interface{})
+	if !ok {
+		return payload
+	}
+	if _, ok := data["token"]; !ok {
+		return payload
+	}
+	data["token"] = "[redacted]"
+	redacted, err := json.Marshal(obj)
+	if err != nil {
+		return payload
+	}
+	return redacted
+}
@@ -0,0 +1,85 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// JWS头部
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+// 生成JWS紧凑序列化签名（alg EdDSA）
+func (k *Keyring) sign(tenantID string, claims *types.CommandClaims) (string, error) {
+	key, err := k.activeKey(tenantID)
+	if err != nil {
+		return "", err
+	}
+
+	h, err := json.Marshal(header{Algorithm: "EdDSA", KeyID: key.ID, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal command claims: %v", err)
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	signature := ed25519.Sign(key.Private, []byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// 为已编码的命令附加签名：JSON命令增加 nonce、expires_at 和 signature 字段，
+// 纯文本命令（SigningAll 模式）追加 "#<jws>"；未启用签名时原样返回
+func (k *Keyring) SignCommand(tenantID, deviceID, topic, commandName string, params map[string]interface{}, payload []byte) ([]byte, error) {
+	plain := !bytes.HasPrefix(bytes.TrimSpace(payload), []byte("{"))
+	if !k.Enabled(plain) {
+		return payload, nil
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	now := time.Now()
+	claims := &types.CommandClaims{
+		TenantID:  tenantID,
+		DeviceID:  deviceID,
+		Topic:     topic,
+		Command:   commandName,
+		Params:    params,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(k.ttl).Unix(),
+		Nonce:     hex.EncodeToString(nonce),
+	}
+	jws, err := k.sign(tenantID, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if plain {
+		return []byte(string(payload) + "#" + jws), nil
+	}
+
+	// 保留原有字段，只增加签名字段
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, fmt.Errorf("failed to sign command: payload is not a JSON object: %v", err)
+	}
+	fields["nonce"], _ = json.Marshal(claims.Nonce)
+	fields["expires_at"], _ = json.Marshal(claims.ExpiresAt)
+	fields["signature"], _ = json.Marshal(jws)
+	return json.Marshal(fields)
+}
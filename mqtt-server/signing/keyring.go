@@ -0,0 +1,272 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"mobile-admin-mqtt-server/logging"
+	"mobile-admin-mqtt-server/types"
+)
+
+var logger = logging.Logger("signing")
+
+// 命令默认有效期
+const DefaultTTL = 60 * time.Second
+
+// 签名密钥
+type key struct {
+	ID        string
+	CreatedAt time.Time
+	RetiredAt *time.Time
+	Private   ed25519.PrivateKey
+}
+
+// 密钥文件格式（私钥只保存种子）
+type keyFile struct {
+	TenantID  string     `json:"tenant_id"`
+	KeyID     string     `json:"kid"`
+	Seed      string     `json:"seed"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// 按租户管理的命令签名密钥（Ed25519），每个租户有一个当前密钥，轮换后旧密钥保留用于验证
+type Keyring struct {
+	mode string
+	ttl  time.Duration
+	path string
+
+	keys  map[string][]*key
+	mutex sync.RWMutex
+}
+
+// 创建密钥环，mode为 types.Signing*，ttl为命令有效期
+func NewKeyring(mode string, ttl time.Duration) (*Keyring, error) {
+	switch mode {
+	case types.SigningOff, types.SigningJSON, types.SigningAll:
+	default:
+		return nil, fmt.Errorf("unknown command signing mode %q", mode)
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Keyring{
+		mode: mode,
+		ttl:  ttl,
+		keys: make(map[string][]*key),
+	}, nil
+}
+
+// 签名模式
+func (k *Keyring) Mode() string {
+	return k.mode
+}
+
+// 是否签名该编码的命令
+func (k *Keyring) Enabled(plain bool) bool {
+	if plain {
+		return k.mode == types.SigningAll
+	}
+	return k.mode != types.SigningOff
+}
+
+// 从文件加载密钥，之后的密钥变更都会写回该文件；文件不存在时从空密钥环开始
+func (k *Keyring) Load(path string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.path = path
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read signing key file: %v", err)
+	}
+
+	var entries []keyFile
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return fmt.Errorf("failed to parse signing key file %s: %v", path, err)
+	}
+	for _, e := range entries {
+		seed, err := base64.RawURLEncoding.DecodeString(e.Seed)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return fmt.Errorf("signing key file %s: invalid seed for key %s", path, e.KeyID)
+		}
+		k.keys[e.TenantID] = append(k.keys[e.TenantID], &key{
+			ID:        e.KeyID,
+			CreatedAt: e.CreatedAt,
+			RetiredAt: e.RetiredAt,
+			Private:   ed25519.NewKeyFromSeed(seed),
+		})
+	}
+	for _, keys := range k.keys {
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		})
+	}
+	logger.Info("Loaded signing keys", "count", len(entries), "path", path)
+	return nil
+}
+
+// 写回密钥文件（权限0600），调用方需持有锁
+func (k *Keyring) save() {
+	if k.path == "" {
+		return
+	}
+
+	entries := make([]keyFile, 0)
+	for tenantID, keys := range k.keys {
+		for _, key := range keys {
+			entries = append(entries, keyFile{
+				TenantID:  tenantID,
+				KeyID:     key.ID,
+				Seed:      base64.RawURLEncoding.EncodeToString(key.Private.Seed()),
+				CreatedAt: key.CreatedAt,
+				RetiredAt: key.RetiredAt,
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].TenantID != entries[j].TenantID {
+			return entries[i].TenantID < entries[j].TenantID
+		}
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	raw, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		logger.Error("Failed to marshal signing keys", "error", err)
+		return
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		logger.Error("Failed to write signing key file", "path", k.path, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		logger.Error("Failed to write signing key file", "path", k.path, "error", err)
+	}
+}
+
+// 生成新密钥并将租户之前的当前密钥标记为retired，调用方需持有锁
+func (k *Keyring) generate(tenantID string) (*key, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate key id: %v", err)
+	}
+
+	now := time.Now()
+	for _, old := range k.keys[tenantID] {
+		if old.RetiredAt == nil {
+			old.RetiredAt = &now
+		}
+	}
+	key := &key{ID: hex.EncodeToString(id), CreatedAt: now, Private: private}
+	k.keys[tenantID] = append(k.keys[tenantID], key)
+	k.save()
+	logger.Info("Generated signing key", "tenant_id", tenantID, "key_id", key.ID)
+	return key, nil
+}
+
+// 租户的当前密钥，没有时生成
+func (k *Keyring) activeKey(tenantID string) (*key, error) {
+	k.mutex.RLock()
+	keys := k.keys[tenantID]
+	if n := len(keys); n > 0 && keys[n-1].RetiredAt == nil {
+		k.mutex.RUnlock()
+		return keys[n-1], nil
+	}
+	k.mutex.RUnlock()
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	keys = k.keys[tenantID]
+	if n := len(keys); n > 0 && keys[n-1].RetiredAt == nil {
+		return keys[n-1], nil
+	}
+	return k.generate(tenantID)
+}
+
+// 轮换租户的签名密钥，返回新密钥
+func (k *Keyring) Rotate(tenantID string) (*types.SigningKey, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key, err := k.generate(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return publicKey(key), nil
+}
+
+// 删除已retired的密钥，之后用该密钥签名的命令无法再验证
+func (k *Keyring) Delete(tenantID, keyID string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	keys := k.keys[tenantID]
+	for i, key := range keys {
+		if key.ID != keyID {
+			continue
+		}
+		if key.RetiredAt == nil {
+			return fmt.Errorf("key %s is active; rotate before deleting it", keyID)
+		}
+		k.keys[tenantID] = append(keys[:i:i], keys[i+1:]...)
+		k.save()
+		logger.Info("Deleted signing key", "tenant_id", tenantID, "key_id", keyID)
+		return nil
+	}
+	return fmt.Errorf("signing key not found: %s", keyID)
+}
+
+// 租户的公钥列表（最新的在前），启用签名时没有密钥会先生成
+func (k *Keyring) Keys(tenantID string) ([]*types.SigningKey, error) {
+	if k.mode != types.SigningOff {
+		if _, err := k.activeKey(tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	keys := k.keys[tenantID]
+	result := make([]*types.SigningKey, 0, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		result = append(result, publicKey(keys[i]))
+	}
+	return result, nil
+}
+
+// 公钥的JWK表示
+func publicKey(key *key) *types.SigningKey {
+	status := types.KeyActive
+	if key.RetiredAt != nil {
+		status = types.KeyRetired
+	}
+	return &types.SigningKey{
+		KeyType:   "OKP",
+		Curve:     "Ed25519",
+		X:         base64.RawURLEncoding.EncodeToString(key.Private.Public().(ed25519.PublicKey)),
+		KeyID:     key.ID,
+		Use:       "sig",
+		Algorithm: "EdDSA",
+		Status:    status,
+		CreatedAt: key.CreatedAt,
+		RetiredAt: key.RetiredAt,
+	}
+}
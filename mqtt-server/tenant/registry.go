@@ -0,0 +1,283 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mobile-admin-mqtt-server/topics"
+	"mobile-admin-mqtt-server/types"
+)
+
+// 未配置租户文件时使用的默认租户
+const DefaultID = "default"
+
+// 租户：独立的主题命名空间、设备分区、API凭证和配额
+type Tenant struct {
+	ID                   string
+	Name                 string
+	APIKey               string
+	MaxDevices           int
+	MaxCommandsPerMinute int
+	// 设备注册准入模式（types.Enrollment*）
+	Enrollment string
+	Topics     *topics.Tree
+
+	mutex         sync.Mutex
+	windowStart   time.Time
+	commandsInWin int
+	// 未配置 MaxDevices 时使用的全局设备数上限，可重新加载
+	defaultMaxDevices int
+}
+
+// 设备数上限（0表示不限制）
+func (t *Tenant) DeviceQuota() int {
+	if t.MaxDevices > 0 {
+		return t.MaxDevices
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.defaultMaxDevices
+}
+
+// 检查并占用一次命令配额（按分钟的固定窗口）
+func (t *Tenant) AllowCommand() bool {
+	if t.MaxCommandsPerMinute <= 0 {
+		return true
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.windowStart) >= time.Minute {
+		t.windowStart = now
+		t.commandsInWin = 0
+	}
+	if t.commandsInWin >= t.MaxCommandsPerMinute {
+		return false
+	}
+	t.commandsInWin++
+	return true
+}
+
+// 租户注册表
+type Registry struct {
+	tenants map[string]*Tenant
+	// API Key 可在运行时替换（SetAPIKeys），读写需持有keyMutex
+	byKey    map[string]*Tenant
+	keyMutex sync.RWMutex
+	// 按前缀长度降序，匹配主题时优先最长前缀
+	ordered []*Tenant
+}
+
+// 创建只包含默认租户的注册表（单租户模式，API无需凭证）
+func NewDefaultRegistry(basePrefix string, templates map[string]string) (*Registry, error) {
+	return NewRegistry([]types.TenantConfig{{ID: DefaultID, Name: "Default"}}, basePrefix, templates)
+}
+
+// 根据租户配置创建注册表，租户前缀拼接在basePrefix之后
+func NewRegistry(configs []types.TenantConfig, basePrefix string, templates map[string]string) (*Registry, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no tenants configured")
+	}
+
+	r := &Registry{
+		tenants: make(map[string]*Tenant),
+		byKey:   make(map[string]*Tenant),
+	}
+
+	for _, cfg := range configs {
+		if cfg.ID == "" {
+			return nil, fmt.Errorf("tenant id is required")
+		}
+		if _, exists := r.tenants[cfg.ID]; exists {
+			return nil, fmt.Errorf("duplicate tenant id: %s", cfg.ID)
+		}
+		if len(configs) > 1 {
+			if cfg.APIKey == "" {
+				return nil, fmt.Errorf("tenant %s: api_key is required when multiple tenants are configured", cfg.ID)
+			}
+			if strings.Trim(cfg.TopicPrefix, "/") == "" {
+				return nil, fmt.Errorf("tenant %s: topic_prefix is required when multiple tenants are configured", cfg.ID)
+			}
+		}
+		if cfg.APIKey != "" {
+			if _, exists := r.byKey[cfg.APIKey]; exists {
+				return nil, fmt.Errorf("tenant %s: api_key is already used by another tenant", cfg.ID)
+			}
+		}
+
+		if cfg.Enrollment != "" && !ValidEnrollment(cfg.Enrollment) {
+			return nil, fmt.Errorf("tenant %s: unknown enrollment mode %q", cfg.ID, cfg.Enrollment)
+		}
+
+		tree, err := topics.NewTree(joinPrefix(basePrefix, cfg.TopicPrefix), templates)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %v", cfg.ID, err)
+		}
+
+		name := cfg.Name
+		if name == "" {
+			name = cfg.ID
+		}
+
+		t := &Tenant{
+			ID:                   cfg.ID,
+			Name:                 name,
+			APIKey:               cfg.APIKey,
+			MaxDevices:           cfg.MaxDevices,
+			MaxCommandsPerMinute: cfg.MaxCommandsPerMinute,
+			Enrollment:           cfg.Enrollment,
+			Topics:               tree,
+		}
+		r.tenants[t.ID] = t
+		if t.APIKey != "" {
+			r.byKey[t.APIKey] = t
+		}
+		r.ordered = append(r.ordered, t)
+	}
+
+	sort.Slice(r.ordered, func(i, j int) bool {
+		return len(r.ordered[i].Topics.Prefix()) > len(r.ordered[j].Topics.Prefix())
+	})
+
+	// 命名空间不能相互包含，否则一个租户可以向另一个租户的主题发布消息
+	for _, a := range r.ordered {
+		for _, b := range r.ordered {
+			if a != b && b.Topics.InNamespace(a.Topics.Prefix()+"/") {
+				return nil, fmt.Errorf("tenant %s topic namespace overlaps tenant %s", a.ID, b.ID)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// 检查设备注册准入模式是否有效
+func ValidEnrollment(mode string) bool {
+	switch mode {
+	case types.EnrollmentOpen, types.EnrollmentAllowlist, types.EnrollmentQuarantine:
+		return true
+	}
+	return false
+}
+
+// 为未配置准入模式的租户设置默认模式
+func (r *Registry) SetDefaultEnrollment(mode string) error {
+	if !ValidEnrollment(mode) {
+		return fmt.Errorf("unknown enrollment mode %q", mode)
+	}
+	for _, t := range r.tenants {
+		if t.Enrollment == "" {
+			t.Enrollment = mode
+		}
+	}
+	return nil
+}
+
+// 设置未配置 max_devices 的租户的设备数上限（0表示不限制）
+func (r *Registry) SetDefaultMaxDevices(n int) {
+	for _, t := range r.tenants {
+		t.mutex.Lock()
+		t.defaultMaxDevices = n
+		t.mutex.Unlock()
+	}
+}
+
+// 替换租户的API Key，keys 为空时该租户不再需要凭证
+func (r *Registry) SetAPIKeys(tenantID string, keys []string) error {
+	t, ok := r.tenants[tenantID]
+	if !ok {
+		return fmt.Errorf("tenant %s not found", tenantID)
+	}
+
+	r.keyMutex.Lock()
+	defer r.keyMutex.Unlock()
+
+	for _, key := range keys {
+		if owner, exists := r.byKey[key]; exists && owner != t {
+			return fmt.Errorf("tenant %s: api_key is already used by another tenant", tenantID)
+		}
+	}
+	for key, owner := range r.byKey {
+		if owner == t {
+			delete(r.byKey, key)
+		}
+	}
+	for _, key := range keys {
+		r.byKey[key] = t
+	}
+	return nil
+}
+
+// 从JSON文件加载租户配置
+func LoadRegistry(path, basePrefix string, templates map[string]string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %v", err)
+	}
+
+	var configs []types.TenantConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file %s: %v", path, err)
+	}
+
+	return NewRegistry(configs, basePrefix, templates)
+}
+
+// 拼接基础前缀和租户前缀
+func joinPrefix(base, prefix string) string {
+	base = strings.Trim(base, "/")
+	prefix = strings.Trim(prefix, "/")
+	switch {
+	case base == "":
+		return prefix
+	case prefix == "":
+		return base
+	default:
+		return base + "/" + prefix
+	}
+}
+
+// 是否需要API凭证（配置了任一API Key即需要）
+func (r *Registry) AuthRequired() bool {
+	r.keyMutex.RLock()
+	defer r.keyMutex.RUnlock()
+	return len(r.byKey) > 0
+}
+
+// 获取租户
+func (r *Registry) Get(id string) (*Tenant, bool) {
+	t, ok := r.tenants[id]
+	return t, ok
+}
+
+// 根据API Key查找租户
+func (r *Registry) ByAPIKey(key string) (*Tenant, bool) {
+	r.keyMutex.RLock()
+	defer r.keyMutex.RUnlock()
+	t, ok := r.byKey[key]
+	return t, ok
+}
+
+// 根据主题查找所属租户
+func (r *Registry) ByTopic(topic string) (*Tenant, bool) {
+	for _, t := range r.ordered {
+		if t.Topics.InNamespace(topic) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// 获取所有租户
+func (r *Registry) All() []*Tenant {
+	tenants := make([]*Tenant, len(r.ordered))
+	copy(tenants, r.ordered)
+	return tenants
+}
@@ -0,0 +1,296 @@
+package topics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"mobile-admin-mqtt-server/types"
+)
+
+// 主题模板占位符
+const (
+	VarDeviceID   = "device_id"
+	VarDeviceType = "device_type"
+	VarCommand    = "command"
+)
+
+// 主题模板名称（用于配置覆盖）
+const (
+	NameDeviceRegister  = "device_register"
+	NameDeviceStatus    = "device_status"
+	NameDeviceHeartbeat = "device_heartbeat"
+	NameDeviceOffline   = "device_offline"
+	NameCommand         = "command"
+	NameResponse        = "response"
+	NameAndroidCommand  = "android_command"
+	NameAndroidStatus   = "android_status"
+	NameDeviceWill      = "device_will"
+	NameServerStatus    = "server_status"
+	NameDeviceState     = "device_state"
+	NameModemState      = "modem_state"
+	NameConfigDelta     = "config_delta"
+	NameConfigReported  = "config_reported"
+	NameLogUpload       = "log_upload"
+)
+
+// 各模板必须包含的占位符
+var requiredVars = map[string][]string{
+	NameCommand:        {VarDeviceID},
+	NameResponse:       {VarDeviceID},
+	NameAndroidCommand: {VarDeviceType},
+	NameAndroidStatus:  {VarDeviceType},
+	NameDeviceWill:     {VarDeviceID},
+	NameDeviceState:    {VarDeviceID},
+	NameModemState:     {VarDeviceID},
+	NameConfigDelta:    {VarDeviceID},
+	NameConfigReported: {VarDeviceID},
+	NameLogUpload:      {VarDeviceID},
+}
+
+// 默认主题模板，与历史上的固定主题保持一致
+func DefaultTemplates() map[string]string {
+	return map[string]string{
+		NameDeviceRegister:  types.TopicDeviceRegister,
+		NameDeviceStatus:    types.TopicDeviceStatus,
+		NameDeviceHeartbeat: types.TopicDeviceHeartbeat,
+		NameDeviceOffline:   types.TopicDeviceOffline,
+		NameCommand:         types.TopicCommandPrefix + "/{device_id}",
+		NameResponse:        types.TopicResponsePrefix + "/{device_id}",
+		NameAndroidCommand:  types.TopicAndroidDevicePrefix + "/{device_type}/{command}",
+		NameAndroidStatus:   types.TopicAndroidDevicePrefix + "/{device_type}/status",
+		NameDeviceWill:      types.TopicDeviceWillPrefix + "/{device_id}",
+		NameServerStatus:    types.TopicServerStatus,
+		NameDeviceState:     types.TopicServerDevicesPrefix + "/{device_id}/state",
+		NameModemState:      types.TopicModemPrefix + "/{device_id}/state",
+		NameConfigDelta:     types.TopicDeviceConfigPrefix + "/{device_id}/delta",
+		NameConfigReported:  types.TopicDeviceConfigPrefix + "/{device_id}/reported",
+		NameLogUpload:       types.TopicDeviceLogsPrefix + "/{device_id}",
+	}
+}
+
+// 主题树：根前缀 + 各主题模板
+type Tree struct {
+	prefix    string
+	templates map[string]string
+}
+
+// 创建主题树，overrides中的模板覆盖默认值
+func NewTree(prefix string, overrides map[string]string) (*Tree, error) {
+	templates := DefaultTemplates()
+	for name, tmpl := range overrides {
+		if _, ok := templates[name]; !ok {
+			return nil, fmt.Errorf("unknown topic template: %s", name)
+		}
+		templates[name] = strings.Trim(tmpl, "/")
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if strings.ContainsAny(prefix, "+#") {
+		return nil, fmt.Errorf("topic prefix must not contain wildcards: %s", prefix)
+	}
+
+	for name, tmpl := range templates {
+		if err := ValidateTemplate(tmpl); err != nil {
+			return nil, fmt.Errorf("topic template %s: %v", name, err)
+		}
+		if err := checkRequiredVars(name, tmpl); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Tree{prefix: prefix, templates: templates}, nil
+}
+
+// 默认主题树（无前缀）
+func DefaultTree() *Tree {
+	tree, _ := NewTree("", nil)
+	return tree
+}
+
+// 校验主题模板（不含通配符、空层级和未知占位符）
+func ValidateTemplate(tmpl string) error {
+	if tmpl == "" {
+		return fmt.Errorf("template is empty")
+	}
+	if strings.ContainsAny(tmpl, "+#") {
+		return fmt.Errorf("template must not contain wildcards: %s", tmpl)
+	}
+
+	for _, level := range strings.Split(tmpl, "/") {
+		if level == "" {
+			return fmt.Errorf("template has an empty level: %s", tmpl)
+		}
+		if v, ok := placeholder(level); ok {
+			if v != VarDeviceID && v != VarDeviceType && v != VarCommand {
+				return fmt.Errorf("template has unknown placeholder {%s}", v)
+			}
+		}
+	}
+	return nil
+}
+
+// 检查模板是否包含必需的占位符
+func checkRequiredVars(name, tmpl string) error {
+	for _, v := range requiredVars[name] {
+		if !strings.Contains(tmpl, "{"+v+"}") {
+			return fmt.Errorf("topic template %s must contain {%s}: %s", name, v, tmpl)
+		}
+	}
+	return nil
+}
+
+// 解析占位符层级，如 {device_id}
+func placeholder(level string) (string, bool) {
+	if strings.HasPrefix(level, "{") && strings.HasSuffix(level, "}") {
+		return level[1 : len(level)-1], true
+	}
+	return "", false
+}
+
+// 获取根前缀
+func (t *Tree) Prefix() string {
+	return t.prefix
+}
+
+// 获取原始模板
+func (t *Tree) Template(name string) string {
+	return t.templates[name]
+}
+
+// 获取带前缀的完整模板（用于展示）
+func (t *Tree) Pattern(name string) string {
+	return t.withPrefix(t.Resolve(name))
+}
+
+// 解析模板：已知名称返回对应模板，否则视为自定义模板原样返回
+func (t *Tree) Resolve(nameOrTemplate string) string {
+	if tmpl, ok := t.templates[nameOrTemplate]; ok {
+		return tmpl
+	}
+	return strings.Trim(nameOrTemplate, "/")
+}
+
+// 获取所有模板（副本）
+func (t *Tree) Templates() map[string]string {
+	templates := make(map[string]string, len(t.templates))
+	for name, tmpl := range t.templates {
+		templates[name] = tmpl
+	}
+	return templates
+}
+
+// 获取所有模板名称（已排序）
+func (t *Tree) Names() []string {
+	names := make([]string, 0, len(t.templates))
+	for name := range t.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 加上根前缀
+func (t *Tree) withPrefix(topic string) string {
+	if t.prefix == "" {
+		return topic
+	}
+	return t.prefix + "/" + topic
+}
+
+// 渲染主题，vars提供占位符的值；name可以是模板名称或自定义模板
+func (t *Tree) Format(name string, vars map[string]string) string {
+	levels := strings.Split(t.Resolve(name), "/")
+	for i, level := range levels {
+		if v, ok := placeholder(level); ok {
+			levels[i] = vars[v]
+		}
+	}
+	return t.withPrefix(strings.Join(levels, "/"))
+}
+
+// 生成订阅用的通配主题，占位符替换为 +；name可以是模板名称或自定义模板
+func (t *Tree) Filter(name string) string {
+	levels := strings.Split(t.Resolve(name), "/")
+	for i, level := range levels {
+		if _, ok := placeholder(level); ok {
+			levels[i] = "+"
+		}
+	}
+	return t.withPrefix(strings.Join(levels, "/"))
+}
+
+// 匹配主题，成功时返回占位符的值；name可以是模板名称或自定义模板
+func (t *Tree) Match(name, topic string) (map[string]string, bool) {
+	if t.prefix != "" {
+		if !strings.HasPrefix(topic, t.prefix+"/") {
+			return nil, false
+		}
+		topic = strings.TrimPrefix(topic, t.prefix+"/")
+	}
+
+	levels := strings.Split(t.Resolve(name), "/")
+	parts := strings.Split(topic, "/")
+	if len(levels) != len(parts) {
+		return nil, false
+	}
+
+	vars := make(map[string]string)
+	for i, level := range levels {
+		if v, ok := placeholder(level); ok {
+			if parts[i] == "" {
+				return nil, false
+			}
+			vars[v] = parts[i]
+			continue
+		}
+		if level != parts[i] {
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+// 检查主题是否位于本命名空间内
+func (t *Tree) InNamespace(topic string) bool {
+	return t.prefix == "" || strings.HasPrefix(topic, t.prefix+"/")
+}
+
+// 将原始主题限定在命名空间内（未带前缀时自动加上）
+func (t *Tree) Qualify(topic string) string {
+	topic = strings.Trim(topic, "/")
+	if t.InNamespace(topic) {
+		return topic
+	}
+	return t.withPrefix(topic)
+}
+
+// 设备命令主题
+func (t *Tree) Command(deviceID string) string {
+	return t.Format(NameCommand, map[string]string{VarDeviceID: deviceID})
+}
+
+// 设备响应主题
+func (t *Tree) Response(deviceID string) string {
+	return t.Format(NameResponse, map[string]string{VarDeviceID: deviceID})
+}
+
+// Android客户端命令主题
+func (t *Tree) AndroidCommand(deviceType, command string) string {
+	return t.Format(NameAndroidCommand, map[string]string{VarDeviceType: deviceType, VarCommand: command})
+}
+
+// 设备状态快照主题
+func (t *Tree) DeviceState(deviceID string) string {
+	return t.Format(NameDeviceState, map[string]string{VarDeviceID: deviceID})
+}
+
+// 设备配置差异主题
+func (t *Tree) ConfigDelta(deviceID string) string {
+	return t.Format(NameConfigDelta, map[string]string{VarDeviceID: deviceID})
+}
+
+// 服务端状态主题
+func (t *Tree) ServerStatus() string {
+	return t.Format(NameServerStatus, nil)
+}
@@ -0,0 +1,117 @@
+package topics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	prefixed, err := NewTree("fleet/acme", map[string]string{NameCommand: "cmd/{device_id}/in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		tree  *Tree
+		tmpl  string
+		topic string
+		vars  map[string]string
+		ok    bool
+	}{
+		{name: "named template", tree: DefaultTree(), tmpl: NameCommand, topic: "device/command/phone-01", vars: map[string]string{VarDeviceID: "phone-01"}, ok: true},
+		{name: "custom template", tree: DefaultTree(), tmpl: "device/{device_type}/status", topic: "device/oppo/status", vars: map[string]string{VarDeviceType: "oppo"}, ok: true},
+		{name: "several placeholders", tree: DefaultTree(), tmpl: NameAndroidCommand, topic: "device/oppo/restart4g", vars: map[string]string{VarDeviceType: "oppo", VarCommand: "restart4g"}, ok: true},
+		{name: "fixed template", tree: DefaultTree(), tmpl: NameDeviceRegister, topic: "device/register", vars: map[string]string{}, ok: true},
+		{name: "literal mismatch", tree: DefaultTree(), tmpl: NameCommand, topic: "device/response/phone-01"},
+		{name: "too many levels", tree: DefaultTree(), tmpl: NameCommand, topic: "device/command/phone-01/extra"},
+		{name: "too few levels", tree: DefaultTree(), tmpl: NameCommand, topic: "device/command"},
+		{name: "empty placeholder", tree: DefaultTree(), tmpl: NameCommand, topic: "device/command/"},
+		{name: "prefixed override", tree: prefixed, tmpl: NameCommand, topic: "fleet/acme/cmd/phone-01/in", vars: map[string]string{VarDeviceID: "phone-01"}, ok: true},
+		{name: "missing prefix", tree: prefixed, tmpl: NameCommand, topic: "cmd/phone-01/in"},
+		{name: "other prefix", tree: prefixed, tmpl: NameCommand, topic: "fleet/other/cmd/phone-01/in"},
+		{name: "prefix is not a level boundary", tree: prefixed, tmpl: NameCommand, topic: "fleet/acmecmd/phone-01/in"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, ok := tt.tree.Match(tt.tmpl, tt.topic)
+			if ok != tt.ok {
+				t.Fatalf("Match(%q, %q) ok=%v, want %v", tt.tmpl, tt.topic, ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(vars, tt.vars) {
+				t.Errorf("Match(%q, %q) vars %v, want %v", tt.tmpl, tt.topic, vars, tt.vars)
+			}
+		})
+	}
+}
+
+func TestFormatMatchRoundTrip(t *testing.T) {
+	tree, err := NewTree("tenant-a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{VarDeviceID: "phone-01", VarDeviceType: "oppo", VarCommand: "restart4g"}
+	for _, name := range tree.Names() {
+		topic := tree.Format(name, vars)
+		got, ok := tree.Match(name, topic)
+		if !ok {
+			t.Errorf("%s: %q does not match its own template", name, topic)
+			continue
+		}
+		for v, value := range got {
+			if vars[v] != value {
+				t.Errorf("%s: %s=%q, want %q", name, v, value, vars[v])
+			}
+		}
+	}
+}
+
+func TestQualify(t *testing.T) {
+	prefixed, err := NewTree("/fleet/acme/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		tree  *Tree
+		topic string
+		want  string
+	}{
+		{name: "no prefix", tree: DefaultTree(), topic: "device/custom", want: "device/custom"},
+		{name: "no prefix trims slashes", tree: DefaultTree(), topic: "/device/custom/", want: "device/custom"},
+		{name: "adds prefix", tree: prefixed, topic: "device/custom", want: "fleet/acme/device/custom"},
+		{name: "keeps qualified topic", tree: prefixed, topic: "fleet/acme/device/custom", want: "fleet/acme/device/custom"},
+		{name: "trims before qualifying", tree: prefixed, topic: "/fleet/acme/device/custom", want: "fleet/acme/device/custom"},
+		{name: "similar prefix is not qualified", tree: prefixed, topic: "fleet/acme2/device", want: "fleet/acme/fleet/acme2/device"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tree.Qualify(tt.topic); got != tt.want {
+				t.Errorf("Qualify(%q) = %q, want %q", tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTreeInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		overrides map[string]string
+	}{
+		{name: "wildcard prefix", prefix: "fleet/+"},
+		{name: "unknown template", overrides: map[string]string{"nope": "a/b"}},
+		{name: "wildcard template", overrides: map[string]string{NameCommand: "cmd/#/{device_id}"}},
+		{name: "empty level", overrides: map[string]string{NameCommand: "cmd//{device_id}"}},
+		{name: "unknown placeholder", overrides: map[string]string{NameCommand: "cmd/{device_id}/{serial}"}},
+		{name: "missing required placeholder", overrides: map[string]string{NameCommand: "cmd/all"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTree(tt.prefix, tt.overrides); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// span 导出方式
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// MQTT消息中携带追踪上下文的字段（W3C Trace Context），设备在响应中原样返回
+const (
+	FieldTraceParent = "traceparent"
+	FieldTraceState  = "tracestate"
+)
+
+// 追踪配置
+type Options struct {
+	Exporter string
+	// OTLP/HTTP 接收地址（如 http://localhost:4318），为空时使用 OTEL_EXPORTER_OTLP_* 环境变量或默认地址
+	Endpoint string
+	// 新追踪的采样比例（0-1），请求已带追踪上下文时沿用上游的采样决定
+	SampleRatio float64
+	ServiceName string
+}
+
+// 校验追踪配置
+func (o Options) Validate() error {
+	switch o.Exporter {
+	case ExporterNone, ExporterStdout, ExporterOTLP:
+	default:
+		return fmt.Errorf("unknown trace exporter %q (use %s, %s or %s)", o.Exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+	}
+	if o.Endpoint != "" {
+		if u, err := url.Parse(o.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint must be an absolute http(s) URL, got %q", o.Endpoint)
+		}
+	}
+	if o.SampleRatio < 0 || o.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1, got %g", o.SampleRatio)
+	}
+	if o.ServiceName == "" {
+		return fmt.Errorf("service name must not be empty")
+	}
+	return nil
+}
+
+func init() {
+	// 未启用导出时也传递上游的追踪上下文，便于与客户端日志关联
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// 按配置创建导出器并设置全局 TracerProvider；返回的函数导出剩余的span并关闭导出器
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(opts.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %v", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(opts.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	Install(provider)
+	return provider.Shutdown, nil
+}
+
+// 设置全局 TracerProvider。测试中可传入使用内存导出器的 provider（见 mqtt/tracing_test.go）：
+//
+//	exporter := tracetest.NewInMemoryExporter()
+//	tracing.Install(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+func Install(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+}
+
+// 获取组件的 tracer；可在 Setup 之前创建，之后设置的 provider 同样生效
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer("mobile-admin-mqtt-server/" + name)
+}
+
+// 结束span，err 非空时记录错误
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// 把当前追踪上下文写入JSON消息载荷的 traceparent/tracestate 字段；
+// 纯文本载荷或没有有效追踪上下文时原样返回
+func InjectPayload(ctx context.Context, payload []byte) []byte {
+	if !bytes.HasPrefix(bytes.TrimSpace(payload), []byte("{")) {
+		return payload
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if carrier[FieldTraceParent] == "" {
+		return payload
+	}
+
+	// 保留原有字段，只增加追踪字段
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return payload
+	}
+	for _, key := range []string{FieldTraceParent, FieldTraceState} {
+		if value := carrier[key]; value != "" {
+			fields[key], _ = json.Marshal(value)
+		}
+	}
+	injected, err := json.Marshal(fields)
+	if err != nil {
+		return payload
+	}
+	return injected
+}
+
+// 从设备消息的JSON载荷中读取追踪上下文，没有时返回原ctx
+func ExtractPayload(ctx context.Context, payload []byte) context.Context {
+	if !bytes.HasPrefix(bytes.TrimSpace(payload), []byte("{")) {
+		return ctx
+	}
+	var fields struct {
+		TraceParent string `json:"traceparent"`
+		TraceState  string `json:"tracestate"`
+	}
+	if err := json.Unmarshal(payload, &fields); err != nil || fields.TraceParent == "" {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{FieldTraceParent: fields.TraceParent}
+	if fields.TraceState != "" {
+		carrier[FieldTraceState] = fields.TraceState
+	}
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
@@ -5,12 +5,120 @@ import "time"
 // 设备信息结构
 type Device struct {
 	ID            string            `json:"device_id"`
+	TenantID      string            `json:"tenant_id,omitempty"`
 	ClientID      string            `json:"client_id"`
+	Protocol      string            `json:"protocol"`
 	LastSeen      time.Time         `json:"last_seen"`
 	NetworkStatus string            `json:"network_status"`
+	StatusDetail  string            `json:"status_detail,omitempty"`
 	LastAction    string            `json:"last_action,omitempty"`
 	DeviceInfo    map[string]string `json:"device_info,omitempty"`
 	IsOnline      bool              `json:"is_online"`
+
+	// 协商后的协议版本和设备声明能执行的命令；未声明能力（nil）的旧设备不限制命令
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+
+	// 运维人员维护的名称、位置、备注和设备信息覆盖项，设备重新注册时保留
+	Name          string            `json:"name,omitempty"`
+	Location      string            `json:"location,omitempty"`
+	Notes         string            `json:"notes,omitempty"`
+	InfoOverrides map[string]string `json:"info_overrides,omitempty"`
+
+	// 规范化的网络状态（状态机维护）和设备上报的原始状态字符串
+	Status          NetworkState `json:"status"`
+	RawStatus       string       `json:"raw_status,omitempty"`
+	StatusChangedAt time.Time    `json:"status_changed_at"`
+	// 最近一次不符合状态机的状态变化
+	IllegalTransition *StatusTransition `json:"illegal_transition,omitempty"`
+
+	// 最新的遥测数据（各字段取最近一次上报的值）和有限长度的历史记录
+	Telemetry        *Telemetry  `json:"telemetry,omitempty"`
+	TelemetryHistory []Telemetry `json:"-"`
+
+	// 当前IP（优先公网IP，未上报时使用蜂窝网络IP）、IP变化记录和最近一次重启类命令的结果
+	PublicIP    string         `json:"public_ip,omitempty"`
+	IPSource    string         `json:"ip_source,omitempty"`
+	IPHistory   []IPChange     `json:"-"`
+	// 最近上报的蜂窝网络IP（已知公网IP时不作为当前IP，但用于判断重启结果）
+	CellularIP string `json:"cellular_ip,omitempty"`
+	LastRestart *RestartResult `json:"last_restart,omitempty"`
+}
+
+// IP变化记录
+type IPChange struct {
+	IP       string    `json:"ip"`
+	Previous string    `json:"previous,omitempty"`
+	Source   string    `json:"source"`
+	At       time.Time `json:"at"`
+}
+
+// 设备IP跟踪信息（GET /devices/{id}/ips）
+type DeviceIPReport struct {
+	DeviceID    string         `json:"device_id"`
+	CurrentIP   string         `json:"current_ip"`
+	IPSource    string         `json:"ip_source,omitempty"`
+	History     []IPChange     `json:"history"`
+	LastRestart *RestartResult `json:"last_restart,omitempty"`
+}
+
+// 重启类命令（Spec.ChangesIP）的结果
+type RestartResult struct {
+	Command     string     `json:"command"`
+	Status      string     `json:"status"`
+	IPBefore    string     `json:"ip_before,omitempty"`
+	IPAfter     string     `json:"ip_after,omitempty"`
+	NewIP       bool       `json:"new_ip"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Deadline    time.Time  `json:"deadline"`
+	// 命令的结果状态，设备上报其中之一即视为命令已执行完
+	ResultStatuses []string `json:"-"`
+	// 重启前的蜂窝网络IP，设备重启后只上报蜂窝网络IP时与之比较
+	CellularIPBefore string `json:"-"`
+}
+
+// 设备遥测数据，未上报的字段为空
+type Telemetry struct {
+	Timestamp int64 `json:"timestamp"`
+	// 信号质量：RSRP (dBm)、RSRQ (dB)、SINR (dB)
+	RSRP *float64 `json:"rsrp,omitempty"`
+	RSRQ *float64 `json:"rsrq,omitempty"`
+	SINR *float64 `json:"sinr,omitempty"`
+	// 运营商、小区ID、网络类型（LTE/NR/3G）
+	Carrier     string `json:"carrier,omitempty"`
+	CellID      string `json:"cell_id,omitempty"`
+	NetworkType string `json:"network_type,omitempty"`
+	// 蜂窝网络接口的IP地址和公网出口IP
+	IPAddress string `json:"ip_address,omitempty"`
+	PublicIP  string `json:"public_ip,omitempty"`
+	// 电量百分比和是否充电
+	Battery  *int  `json:"battery,omitempty"`
+	Charging *bool `json:"charging,omitempty"`
+	// 移动数据累计流量（字节）
+	DataRxBytes *int64 `json:"data_rx_bytes,omitempty"`
+	DataTxBytes *int64 `json:"data_tx_bytes,omitempty"`
+}
+
+// 状态变化记录
+type StatusTransition struct {
+	From      NetworkState `json:"from"`
+	To        NetworkState `json:"to"`
+	RawStatus string       `json:"raw_status"`
+	At        time.Time    `json:"at"`
+}
+
+// 设备状态快照（retained发布到 server/devices/{device_id}/state）
+type DeviceState struct {
+	DeviceID      string            `json:"device_id"`
+	Online        bool              `json:"online"`
+	NetworkStatus string            `json:"network_status"`
+	StatusDetail  string            `json:"status_detail,omitempty"`
+	Status        NetworkState      `json:"status"`
+	LastSeen      int64             `json:"last_seen"`
+	DeviceInfo    map[string]string `json:"device_info,omitempty"`
+	Name          string            `json:"name,omitempty"`
+	Location      string            `json:"location,omitempty"`
 }
 
 // MQTT消息结构
@@ -20,6 +128,159 @@ type MQTTMessage struct {
 	Timestamp int64                  `json:"timestamp"`
 	Data      map[string]interface{} `json:"data,omitempty"`
 	DeviceID  string                 `json:"device_id,omitempty"`
+	// 消息格式版本，未提供时为1
+	SchemaVersion int `json:"schema_version,omitempty"`
+
+	// 命令签名（启用命令签名时）：随机数、过期时间（Unix秒）和JWS签名
+	Nonce     string `json:"nonce,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// 注册消息的data
+type RegisterData struct {
+	DeviceID   string            `json:"device_id"`
+	ClientID   string            `json:"client_id,omitempty"`
+	Protocol   string            `json:"protocol,omitempty"`
+	Token      string            `json:"token,omitempty"`
+	DeviceInfo map[string]string `json:"device_info,omitempty"`
+	// 设备支持的协议版本和能执行的命令
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+}
+
+// 心跳消息的data
+type HeartbeatData struct {
+	DeviceID  string     `json:"device_id,omitempty"`
+	Telemetry *Telemetry `json:"telemetry,omitempty"`
+}
+
+// 离线消息的data
+type OfflineData struct {
+	DeviceID string `json:"device_id,omitempty"`
+}
+
+// 设备配置（影子）：desired 由运维人员设置，reported 由设备上报，
+// delta 和 diff 为 desired 中与 reported 不一致的项
+type DeviceConfig struct {
+	DeviceID string                 `json:"device_id"`
+	Desired  map[string]interface{} `json:"desired"`
+	Reported map[string]interface{} `json:"reported"`
+	Delta    map[string]interface{} `json:"delta"`
+	Diff     []ConfigDiff           `json:"diff"`
+	// desired 每次修改加1；reported_version 为设备最近一次上报时已应用的版本
+	Version         int64      `json:"version"`
+	ReportedVersion int64      `json:"reported_version"`
+	InSync          bool       `json:"in_sync"`
+	DesiredAt       *time.Time `json:"desired_at,omitempty"`
+	ReportedAt      *time.Time `json:"reported_at,omitempty"`
+}
+
+// 配置项差异，reported 为空表示设备未上报该项
+type ConfigDiff struct {
+	Key      string      `json:"key"`
+	Desired  interface{} `json:"desired"`
+	Reported interface{} `json:"reported"`
+}
+
+// 设置设备期望配置的请求，desired 整体替换原有的期望配置
+type ConfigUpdate struct {
+	Desired map[string]interface{} `json:"desired"`
+	// 当前版本，与服务端不一致时拒绝更新（避免覆盖他人的修改），不提供时不检查
+	Version *int64 `json:"version,omitempty"`
+}
+
+// 设备上报配置消息的data
+type ConfigReportData struct {
+	Version  int64                  `json:"version"`
+	Reported map[string]interface{} `json:"reported"`
+}
+
+// 设备上传的日志分片（device/logs/{device_id} 消息的data）
+type LogChunkData struct {
+	BundleID string `json:"bundle_id"`
+	// 分片序号（从0开始）和分片总数
+	Seq   int `json:"seq"`
+	Total int `json:"total"`
+	// base64编码的日志内容
+	Data string `json:"data"`
+}
+
+// 设备日志包：collect_logs 命令请求的日志，分片上传后在服务端合并保存
+type LogBundle struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenant_id"`
+	DeviceID string `json:"device_id"`
+	Status   string `json:"status"`
+	// 下发给设备的 collect_logs 参数
+	Params         map[string]interface{} `json:"params,omitempty"`
+	TotalChunks    int                    `json:"total_chunks,omitempty"`
+	ReceivedChunks int                    `json:"received_chunks"`
+	// 已接收的字节数，完成后为日志大小
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	RequestedAt time.Time  `json:"requested_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// 制品库中的应用版本（APK）
+type AppRelease struct {
+	// Android的 versionCode（递增的整数）和 versionName
+	VersionCode  int64     `json:"version_code"`
+	Version      string    `json:"version"`
+	TenantID     string    `json:"tenant_id"`
+	FileName     string    `json:"file_name"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	ReleaseNotes string    `json:"release_notes,omitempty"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+}
+
+// 运行某个应用版本的设备
+type AppVersionDevices struct {
+	VersionCode int64  `json:"version_code,omitempty"`
+	Version     string `json:"version,omitempty"`
+	// 是否为制品库中的版本
+	Released bool     `json:"released"`
+	Devices  []string `json:"devices"`
+	Online   int      `json:"online"`
+}
+
+// 应用版本分布：各版本上运行的设备
+type AppRollout struct {
+	LatestVersionCode int64 `json:"latest_version_code,omitempty"`
+	Total             int   `json:"total"`
+	// 运行最新版本的设备数
+	UpToDate int                 `json:"up_to_date"`
+	Versions []AppVersionDevices `json:"versions"`
+	// 未上报应用版本的设备
+	Unknown []string `json:"unknown"`
+}
+
+// 入站消息校验失败的字段
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// 被拒绝的入站消息样本
+type RejectSample struct {
+	Topic   string       `json:"topic"`
+	Code    string       `json:"code"`
+	Errors  []FieldError `json:"errors,omitempty"`
+	Payload string       `json:"payload"`
+	At      time.Time    `json:"at"`
+}
+
+// 某类入站消息的拒绝统计
+type RejectStats struct {
+	Message string         `json:"message"`
+	Count   int64          `json:"count"`
+	LastAt  time.Time      `json:"last_at"`
+	Samples []RejectSample `json:"samples"`
 }
 
 // 客户端状态结构
@@ -28,13 +289,20 @@ type ClientStatus struct {
 	NetworkStatus string `json:"network_status"`
 	Timestamp     int64  `json:"timestamp"`
 	LastAction    string `json:"last_action,omitempty"`
+	// 设备当前的公网IP（重启4G后上报）
+	PublicIP string `json:"public_ip,omitempty"`
+	// 可选的结构化遥测数据
+	Telemetry *Telemetry `json:"telemetry,omitempty"`
 }
 
 // HTTP API请求结构
 type CommandRequest struct {
-	DeviceID string `json:"device_id"`
-	Command  string `json:"command"`
-	Topic    string `json:"topic,omitempty"`
+	DeviceID string                 `json:"device_id"`
+	Command  string                 `json:"command"`
+	Topic    string                 `json:"topic,omitempty"`
+	Params   map[string]interface{} `json:"params,omitempty"`
+	// 覆盖设备注册时记录的协议（如 json、android_legacy）
+	Protocol string `json:"protocol,omitempty"`
 }
 
 // HTTP API响应结构
@@ -44,6 +312,153 @@ type APIResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// 设备管理更新（PATCH /devices/{id}），未提供的字段保持不变
+type DeviceUpdate struct {
+	Name     *string `json:"name,omitempty"`
+	Location *string `json:"location,omitempty"`
+	Notes    *string `json:"notes,omitempty"`
+	// 覆盖设备信息字段，值为null时删除该字段的覆盖
+	DeviceInfo map[string]*string `json:"device_info,omitempty"`
+	// 只能设为false，手动将设备标记为离线
+	IsOnline *bool `json:"is_online,omitempty"`
+}
+
+// 预配置的设备（注册白名单），令牌只保存SHA-256摘要
+type EnrolledDevice struct {
+	DeviceID  string `json:"device_id"`
+	TenantID  string `json:"tenant_id"`
+	TokenHash string `json:"token_hash,omitempty"`
+	// 来源：api（预配置）或 pending（从待审批列表批准）
+	Source         string     `json:"source"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastAdmittedAt *time.Time `json:"last_admitted_at,omitempty"`
+}
+
+// 预配置设备请求（POST /enrollment/devices），token为空时由服务端生成
+type ProvisionRequest struct {
+	DeviceID string `json:"device_id"`
+	Token    string `json:"token,omitempty"`
+}
+
+// 预配置或批准设备的结果，令牌明文只在此时返回
+type ProvisionResult struct {
+	Device *EnrolledDevice `json:"device"`
+	Token  string          `json:"token"`
+	// 启用命令签名时附带租户公钥，与令牌一起写入设备并固定
+	SigningKeys []*SigningKey `json:"signing_keys,omitempty"`
+}
+
+// 待审批的未知设备（quarantine模式）
+type PendingDevice struct {
+	DeviceID   string            `json:"device_id"`
+	TenantID   string            `json:"tenant_id"`
+	ClientID   string            `json:"client_id,omitempty"`
+	Protocol   string            `json:"protocol,omitempty"`
+	DeviceInfo map[string]string `json:"device_info,omitempty"`
+	// 来源：register（注册消息）或 status（状态主题自动注册）
+	Source    string    `json:"source"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Attempts  int       `json:"attempts"`
+}
+
+// 命令签名公钥（JWK格式，OKP/Ed25519），retired 的密钥仍可用于验证已发出的命令
+type SigningKey struct {
+	KeyType   string     `json:"kty"`
+	Curve     string     `json:"crv"`
+	X         string     `json:"x"`
+	KeyID     string     `json:"kid"`
+	Use       string     `json:"use"`
+	Algorithm string     `json:"alg"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// 签名命令的声明（JWS载荷）
+type CommandClaims struct {
+	TenantID  string                 `json:"tid"`
+	DeviceID  string                 `json:"sub"`
+	Topic     string                 `json:"topic"`
+	Command   string                 `json:"cmd"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+	IssuedAt  int64                  `json:"iat"`
+	ExpiresAt int64                  `json:"exp"`
+	Nonce     string                 `json:"jti"`
+}
+
+// 导出指标所需的设备状态快照
+type DeviceMetrics struct {
+	DeviceID  string
+	IsOnline  bool
+	Status    NetworkState
+	Telemetry *Telemetry
+}
+
+// 设备列表分页结果
+type DevicePage struct {
+	Devices []*Device `json:"devices"`
+	// 符合过滤条件的设备总数及其中在线的数量
+	Total  int `json:"total"`
+	Online int `json:"online"`
+	// 下一页游标，没有更多结果时为空
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// 换IP任务参数（restart_until_new_ip）
+type RotationOptions struct {
+	// 使用的重启类命令，默认 restart4g
+	Command string `json:"command"`
+	// 重启命令的参数（不含默认值），为空时下发不带参数的命令
+	RestartParams map[string]interface{} `json:"restart_params,omitempty"`
+	MaxAttempts   int                    `json:"max_attempts"`
+	// 第二次尝试前的等待时间，之后每次乘以BackoffMultiplier
+	BackoffSeconds    int     `json:"backoff_seconds"`
+	BackoffMultiplier float64 `json:"backoff_multiplier"`
+	// 不接受的IP和IP前缀（如 10.64. 或 CIDR 100.64.0.0/10）
+	Blocklist       []string `json:"blocklist,omitempty"`
+	BlockedPrefixes []string `json:"blocked_prefixes,omitempty"`
+}
+
+// 换IP任务的一次尝试
+type RotationAttempt struct {
+	Attempt    int        `json:"attempt"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// 重启结果状态（见 Restart*），命令下发失败时为 send_failed
+	Status   string `json:"status"`
+	IPBefore string `json:"ip_before,omitempty"`
+	IPAfter  string `json:"ip_after,omitempty"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// 换IP任务：反复重启直到设备换到可接受的新IP
+type RotationJob struct {
+	ID         string            `json:"id"`
+	TenantID   string            `json:"tenant_id"`
+	DeviceID   string            `json:"device_id"`
+	Options    RotationOptions   `json:"options"`
+	Status     string            `json:"status"`
+	OriginalIP string            `json:"original_ip,omitempty"`
+	FinalIP    string            `json:"final_ip,omitempty"`
+	Attempts   []RotationAttempt `json:"attempts"`
+	CreatedAt  time.Time         `json:"created_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+}
+
+// 租户配置结构（租户文件中的一项）
+type TenantConfig struct {
+	ID                   string `json:"id"`
+	Name                 string `json:"name,omitempty"`
+	APIKey               string `json:"api_key,omitempty"`
+	TopicPrefix          string `json:"topic_prefix,omitempty"`
+	MaxDevices           int    `json:"max_devices,omitempty"`
+	MaxCommandsPerMinute int    `json:"max_commands_per_minute,omitempty"`
+	// 设备注册准入模式：open、allowlist、quarantine，为空时使用服务端默认值
+	Enrollment string `json:"enrollment,omitempty"`
+}
+
 // MQTT配置结构
 type MQTTConfig struct {
 	Broker   string `json:"broker"`
@@ -51,6 +466,11 @@ type MQTTConfig struct {
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
 	ClientID string `json:"client_id"`
+
+	// 主题根前缀（如 tenantA/m4g），为空时使用原有主题
+	TopicPrefix string `json:"topic_prefix,omitempty"`
+	// 按名称覆盖的主题模板（如 command: "cmd/{device_id}"）
+	TopicTemplates map[string]string `json:"topic_templates,omitempty"`
 }
 
 // 主题常量
@@ -76,7 +496,128 @@ const (
 	// Android客户端兼容主题前缀 (device/{device_id}/restart4g)
 	TopicAndroidDevicePrefix = "device"
 	
-	// 支持的设备类型
-	DeviceTypeOPPO = "oppo"
-	DeviceTypeGeneric = "generic"
-)
\ No newline at end of file
+	// 设备遗嘱主题前缀 (device/will/{device_id})，设备连接时注册为LWT
+	TopicDeviceWillPrefix = "device/will"
+	
+	// 服务端状态主题（retained），同时作为服务端自身的LWT
+	TopicServerStatus = "server/status"
+	
+	// 设备状态快照主题前缀 (server/devices/{device_id}/state，retained)
+	TopicServerDevicesPrefix = "server/devices"
+	
+	// Linux 4G模块/路由器状态主题前缀 (modem/{device_id}/state)
+	TopicModemPrefix = "modem"
+	
+	// 设备配置主题前缀 (device/config/{device_id}/delta 下发差异；
+	// device/config/{device_id}/reported 设备上报)
+	TopicDeviceConfigPrefix = "device/config"
+
+	// 设备日志上传主题前缀 (device/logs/{device_id})
+	TopicDeviceLogsPrefix = "device/logs"
+	
+	// 服务端状态
+	ServerStatusOnline  = "online"
+	ServerStatusOffline = "offline"
+	
+	// 设备协议（命令编码方式）
+	ProtocolJSON    = "json"
+	ProtocolAndroid = "android_legacy"
+	
+	// 支持的设备类型（同时也是对应设备族适配器的名称）
+	DeviceTypeOPPO       = "oppo"
+	DeviceTypeXiaomi     = "xiaomi"
+	DeviceTypeHuawei     = "huawei"
+	DeviceTypeSamsung    = "samsung"
+	DeviceTypeLinuxModem = "linux_modem"
+	DeviceTypeGeneric    = "generic"
+	
+	// 规范化网络状态，各设备族适配器将上报的状态字符串映射到这些值
+	StatusUnknown         = "unknown"
+	StatusConnected       = "connected"
+	StatusConnecting      = "connecting"
+	StatusDisconnected    = "disconnected"
+	StatusRestarting      = "restarting_4g"
+	StatusRestartSuccess  = "4g_restarted_success"
+	StatusRestartFailed   = "4g_restart_failed"
+	StatusCommandReceived = "command_received"
+	StatusUnknownCommand  = "unknown_command"
+)
+
+// 规范化网络状态（状态机中的状态）
+type NetworkState string
+
+const (
+	StateUnknown       NetworkState = "unknown"
+	StateConnected     NetworkState = "connected"
+	StateConnecting    NetworkState = "connecting"
+	StateDisconnected  NetworkState = "disconnected"
+	StateRestarting    NetworkState = "restarting"
+	StateRestartFailed NetworkState = "restart_failed"
+	StateOffline       NetworkState = "offline"
+)
+
+// IP来源
+const (
+	IPSourcePublic   = "public"
+	IPSourceCellular = "cellular"
+)
+
+// 重启类命令的结果状态
+const (
+	RestartPending    = "pending"     // 已下发，等待设备上报结果
+	RestartAwaitingIP = "awaiting_ip" // 设备已恢复连接，等待上报IP
+	RestartSucceeded  = "success"
+	RestartFailed     = "failed"
+	RestartTimeout    = "timeout"
+)
+
+// 服务端支持的设备协议版本：1为旧版，2起设备可在注册时声明能力
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// 设备在 capabilities 中声明后才会收到 config_delta
+const CapabilityConfigShadow = "config_shadow"
+
+// 设备注册准入模式
+const (
+	// 任何设备都可以注册（默认）
+	EnrollmentOpen = "open"
+	// 只有预配置的设备可以注册，未知设备收到 register_nack
+	EnrollmentAllowlist = "allowlist"
+	// 未知设备进入待审批列表，批准后才能注册
+	EnrollmentQuarantine = "quarantine"
+)
+
+// 命令签名模式
+const (
+	// 不签名（默认）
+	SigningOff = "off"
+	// 只签名JSON命令（新增字段，旧客户端可忽略）
+	SigningJSON = "json"
+	// JSON命令和纯文本命令都签名（纯文本命令追加 "#<jws>"，需要客户端支持）
+	SigningAll = "all"
+)
+
+// 签名密钥状态
+const (
+	KeyActive  = "active"
+	KeyRetired = "retired"
+)
+
+// 换IP任务状态
+const (
+	RotationRunning   = "running"
+	RotationSucceeded = "succeeded"
+	RotationFailed    = "failed"
+	RotationCancelled = "cancelled"
+)
+
+// 日志包状态
+const (
+	LogBundleRequested = "requested"
+	LogBundleReceiving = "receiving"
+	LogBundleComplete  = "complete"
+	LogBundleFailed    = "failed"
+)